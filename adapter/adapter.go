@@ -0,0 +1,42 @@
+// Package adapter adapts an ngrokd dialer to the dial hooks of HTTP client
+// libraries that do not accept a DialContext function directly. It depends
+// only on the standard library, so using an adapter does not pull the
+// client library into the ngrokd module.
+package adapter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	ngrokd "github.com/ngrok-oss/ngrokd-go"
+)
+
+// FastHTTPDial returns a function for fasthttp.Client.Dial. fasthttp passes
+// addresses as host:port, which are dialed unchanged.
+func FastHTTPDial(d ngrokd.ContextDialer) func(addr string) (net.Conn, error) {
+	return func(addr string) (net.Conn, error) {
+		return d.DialContext(context.Background(), "tcp", addr)
+	}
+}
+
+// FastHTTPDialTimeout returns a function for fasthttp.Client.DialTimeout,
+// which bounds each dial by the timeout fasthttp passes.
+func FastHTTPDialTimeout(d ngrokd.ContextDialer) func(addr string, timeout time.Duration) (net.Conn, error) {
+	return func(addr string, timeout time.Duration) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// RestyTransport returns a transport for resty.Client.SetTransport that dials
+// every request through d. Environment proxies are ignored, since the ngrok
+// ingress is the only hop.
+func RestyTransport(d ngrokd.ContextDialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = d.DialContext
+	return transport
+}
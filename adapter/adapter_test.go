@@ -0,0 +1,96 @@
+package adapter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeDialer records dialed addresses and connects every dial to target.
+type fakeDialer struct {
+	target    string
+	addresses []string
+	deadlines []time.Time // zero if the dial context had none
+}
+
+func (d *fakeDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	deadline, _ := ctx.Deadline()
+	d.addresses = append(d.addresses, network+" "+address)
+	d.deadlines = append(d.deadlines, deadline)
+
+	var nd net.Dialer
+	return nd.DialContext(ctx, "tcp", d.target)
+}
+
+func newFakeDialer(t *testing.T) *fakeDialer {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return &fakeDialer{target: srv.Listener.Addr().String()}
+}
+
+func TestFastHTTPDial(t *testing.T) {
+	d := newFakeDialer(t)
+
+	conn, err := FastHTTPDial(d)("app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	conn, err = FastHTTPDialTimeout(d)("db.example:5432", time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	want := []string{"tcp app.example:80", "tcp db.example:5432"}
+	if len(d.addresses) != 2 || d.addresses[0] != want[0] || d.addresses[1] != want[1] {
+		t.Errorf("expected addresses %v, got %v", want, d.addresses)
+	}
+	if !d.deadlines[0].IsZero() || d.deadlines[1].IsZero() {
+		t.Errorf("expected only the timeout variant to set a deadline, got %v", d.deadlines)
+	}
+}
+
+func TestFastHTTPDialTimeout(t *testing.T) {
+	d := newFakeDialer(t)
+
+	start := time.Now()
+	conn, err := FastHTTPDialTimeout(d)("db.example:5432", 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	if len(d.addresses) != 1 || d.addresses[0] != "tcp db.example:5432" {
+		t.Fatalf("expected the address to reach the dialer, got %v", d.addresses)
+	}
+	want := start.Add(5 * time.Second)
+	if got := d.deadlines[0]; got.Before(want) || got.After(want.Add(time.Second)) {
+		t.Errorf("expected a dial deadline of about %v, got %v", want, got)
+	}
+}
+
+func TestRestyTransport(t *testing.T) {
+	d := newFakeDialer(t)
+	client := &http.Client{Transport: RestyTransport(d)}
+
+	resp, err := client.Get("http://app.example:8080/health")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+	if len(d.addresses) != 1 || d.addresses[0] != "tcp app.example:8080" {
+		t.Errorf("expected the request address to be dialed, got %v", d.addresses)
+	}
+}
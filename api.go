@@ -4,38 +4,129 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
 const (
-	defaultAPIURL  = "https://api.ngrok.com"
-	apiVersion     = "2"
+	defaultAPIURL = "https://api.ngrok.com"
+	apiVersion    = "2"
+
+	// defaultAPIKeyTTL is how long a key from Config.APIKeyProvider is reused.
+	defaultAPIKeyTTL = time.Minute
 )
 
 type apiClient struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+
+	// keyProvider, if set, supplies the API key in place of apiKey. Its
+	// result is cached for keyTTL, and dropped when the API rejects it.
+	keyProvider func(ctx context.Context) (string, error)
+	keyTTL      time.Duration
+	keyMu       sync.Mutex
+	cachedKey   string
+	keyExpires  time.Time
+
+	// boundCache holds the last bound endpoints listing per operator, keyed
+	// for conditional requests when the API returns an ETag.
+	mu         sync.Mutex
+	boundCache map[string]boundEndpointsCache
+}
+
+type boundEndpointsCache struct {
+	etag      string
+	endpoints []apiEndpoint
 }
 
 func newAPIClient(apiKey string) *apiClient {
 	return &apiClient{
 		baseURL: defaultAPIURL,
 		apiKey:  apiKey,
+		keyTTL:  defaultAPIKeyTTL,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:       30 * time.Second,
+			CheckRedirect: checkAPIRedirect,
 		},
 	}
 }
 
+// do sends req with the API key attached.
+func (c *apiClient) do(req *http.Request) (*http.Response, error) {
+	key, err := c.key(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := c.httpClient.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		c.dropKey(key)
+	}
+	return resp, err
+}
+
+// key returns the API key, from keyProvider if set.
+func (c *apiClient) key(ctx context.Context) (string, error) {
+	if c.keyProvider == nil {
+		return c.apiKey, nil
+	}
+
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+	if c.cachedKey != "" && time.Now().Before(c.keyExpires) {
+		return c.cachedKey, nil
+	}
+
+	key, err := c.keyProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.cachedKey, c.keyExpires = key, time.Now().Add(c.keyTTL)
+	return key, nil
+}
+
+// dropKey forgets a cached key the API rejected, unless it was already replaced.
+func (c *apiClient) dropKey(key string) {
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+	if c.cachedKey == key {
+		c.cachedKey = ""
+	}
+}
+
+// checkAPIRedirect only follows redirects that stay on the same host and keep
+// the request method, so credentials never leak to another host and a POST is
+// never silently replayed as a GET. Authorization is re-attached explicitly.
+func checkAPIRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	orig := via[0]
+	if req.URL.Host != orig.URL.Host {
+		return fmt.Errorf("refusing API redirect from %s to different host %s", orig.URL.Host, req.URL.Host)
+	}
+	if req.Method != orig.Method {
+		return fmt.Errorf("refusing API redirect that changes %s to %s", orig.Method, req.Method)
+	}
+
+	req.Header.Set("Authorization", orig.Header.Get("Authorization"))
+	return nil
+}
+
 type apiEndpoint struct {
 	ID    string `json:"id"`
 	URL   string `json:"url"`
 	Proto string `json:"proto"`
 	Port  int    `json:"port,omitempty"`
+
+	Metadata string `json:"metadata,omitempty"`
 }
 
 type operatorCreateRequest struct {
@@ -49,11 +140,41 @@ type operatorCreateRequest struct {
 type operatorBindingCreate struct {
 	EndpointSelectors []string `json:"endpoint_selectors,omitempty"`
 	CSR               string   `json:"csr,omitempty"`
+	IngressEndpoint   string   `json:"ingress_endpoint,omitempty"`
+}
+
+type operatorUpdateRequest struct {
+	Binding *operatorBindingUpdate `json:"binding,omitempty"`
+}
+
+type operatorBindingUpdate struct {
+	CSR string `json:"csr,omitempty"`
 }
 
 type operatorResponse struct {
-	ID      string           `json:"id"`
-	Binding *operatorBinding `json:"binding,omitempty"`
+	ID       string           `json:"id"`
+	Metadata string           `json:"metadata,omitempty"`
+	Binding  *operatorBinding `json:"binding,omitempty"`
+
+	// ErrorCode and Msg explain why no certificate was issued, if the API
+	// says so.
+	ErrorCode string `json:"error_code,omitempty"`
+	Msg       string `json:"msg,omitempty"`
+
+	// Raw is the response body as returned by the API, including fields
+	// not modeled here.
+	Raw json.RawMessage `json:"-"`
+}
+
+// DecodeMetadata unmarshals the operator's JSON metadata into v.
+func (o *operatorResponse) DecodeMetadata(v any) error {
+	if o.Metadata == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(o.Metadata), v); err != nil {
+		return fmt.Errorf("invalid operator metadata: %w", err)
+	}
+	return nil
 }
 
 type operatorBinding struct {
@@ -75,10 +196,16 @@ func (c *apiClient) ListBoundEndpoints(ctx context.Context, operatorID string) (
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Ngrok-Version", apiVersion)
 
-	resp, err := c.httpClient.Do(req)
+	c.mu.Lock()
+	cached, haveCached := c.boundCache[operatorID]
+	c.mu.Unlock()
+	if haveCached {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -89,18 +216,24 @@ func (c *apiClient) ListBoundEndpoints(ctx context.Context, operatorID string) (
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
-
 	var result struct {
 		Endpoints []apiEndpoint `json:"endpoints"`
 	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && haveCached:
+		result.Endpoints = cached.endpoints
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	default:
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		c.cacheBoundEndpoints(operatorID, resp.Header.Get("ETag"), result.Endpoints)
 	}
 
-	// Validate endpoints exist by checking against /endpoints API
+	// Validate endpoints exist by checking against /endpoints API, even when
+	// the listing came from the cache
 	validEndpoints, err := c.getValidKubernetesEndpoints(ctx)
 	if err != nil {
 		// If validation fails, return unfiltered (best effort)
@@ -118,6 +251,22 @@ func (c *apiClient) ListBoundEndpoints(ctx context.Context, operatorID string) (
 	return filtered, nil
 }
 
+// cacheBoundEndpoints remembers a listing for conditional requests, or forgets
+// it if the API did not return an ETag.
+func (c *apiClient) cacheBoundEndpoints(operatorID, etag string, endpoints []apiEndpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if etag == "" {
+		delete(c.boundCache, operatorID)
+		return
+	}
+	if c.boundCache == nil {
+		c.boundCache = make(map[string]boundEndpointsCache)
+	}
+	c.boundCache[operatorID] = boundEndpointsCache{etag: etag, endpoints: endpoints}
+}
+
 // getValidKubernetesEndpoints fetches all endpoints with kubernetes binding from /endpoints API
 func (c *apiClient) getValidKubernetesEndpoints(ctx context.Context) (map[string]bool, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/endpoints", nil)
@@ -125,10 +274,9 @@ func (c *apiClient) getValidKubernetesEndpoints(ctx context.Context) (map[string
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Ngrok-Version", apiVersion)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -167,6 +315,43 @@ func (c *apiClient) getValidKubernetesEndpoints(ctx context.Context) (map[string
 	return valid, nil
 }
 
+func (c *apiClient) GetOperator(ctx context.Context, operatorID string) (*operatorResponse, error) {
+	url := fmt.Sprintf("%s/kubernetes_operators/%s", c.baseURL, operatorID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Ngrok-Version", apiVersion)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrOperatorNotFound, operatorID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+	}
+
+	var operator operatorResponse
+	if err := json.Unmarshal(body, &operator); err != nil {
+		return nil, err
+	}
+	operator.Raw = body
+
+	return &operator, nil
+}
+
 func (c *apiClient) CreateOperator(ctx context.Context, req *operatorCreateRequest) (*operatorResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -178,11 +363,10 @@ func (c *apiClient) CreateOperator(ctx context.Context, req *operatorCreateReque
 		return nil, err
 	}
 
-	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Ngrok-Version", apiVersion)
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.do(httpReq)
 	if err != nil {
 		return nil, err
 	}
@@ -201,6 +385,47 @@ func (c *apiClient) CreateOperator(ctx context.Context, req *operatorCreateReque
 	if err := json.Unmarshal(respBody, &operator); err != nil {
 		return nil, err
 	}
+	operator.Raw = respBody
+
+	return &operator, nil
+}
+
+func (c *apiClient) UpdateOperator(ctx context.Context, operatorID string, req *operatorUpdateRequest) (*operatorResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/kubernetes_operators/%s", c.baseURL, operatorID)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Ngrok-Version", apiVersion)
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var operator operatorResponse
+	if err := json.Unmarshal(respBody, &operator); err != nil {
+		return nil, err
+	}
+	operator.Raw = respBody
 
 	return &operator, nil
 }
@@ -213,10 +438,9 @@ func (c *apiClient) DeleteOperator(ctx context.Context, operatorID string) error
 		return err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Ngrok-Version", apiVersion)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
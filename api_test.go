@@ -0,0 +1,1370 @@
+package ngrokd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAPI is a fake ngrok API serving bound endpoints for a single operator.
+type testAPI struct {
+	*httptest.Server
+
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	endpoints []apiEndpoint
+	calls     map[string]int
+	created   []operatorCreateRequest
+	deleted   []string
+
+	// signKey, if set, replaces the CSR's public key in issued certificates.
+	signKey crypto.PublicKey
+
+	// chain, if set, appends the CA to issued certificates.
+	chain bool
+
+	// rejectCreate, if set, is merged into operator create responses in
+	// place of the binding, as the API does when it issues no certificate.
+	rejectCreate map[string]any
+
+	// beforeList, if set, is called with the API lock held before each
+	// bound endpoints listing, with the 1-based call number.
+	beforeList func(call int)
+}
+
+func newTestAPI(t *testing.T, endpoints ...apiEndpoint) *testAPI {
+	t.Helper()
+
+	api := &testAPI{
+		endpoints: endpoints,
+		calls:     make(map[string]int),
+	}
+	api.ca, api.caKey = generateTestCA(t)
+
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		api.calls[r.Method+" "+r.URL.Path]++
+
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/bound_endpoints"):
+			if api.beforeList != nil {
+				api.beforeList(api.calls[r.Method+" "+r.URL.Path])
+			}
+			json.NewEncoder(w).Encode(map[string]any{"endpoints": api.endpoints})
+		case r.Method == "GET" && r.URL.Path == "/endpoints":
+			var eps []map[string]any
+			for _, ep := range api.endpoints {
+				eps = append(eps, map[string]any{"id": ep.ID, "bindings": []string{"kubernetes"}})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"endpoints": eps})
+		case r.Method == "POST" && r.URL.Path == "/kubernetes_operators":
+			var req operatorCreateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			api.created = append(api.created, req)
+
+			if api.rejectCreate != nil {
+				resp := map[string]any{"id": fmt.Sprintf("k8sop_%d", len(api.created))}
+				for k, v := range api.rejectCreate {
+					resp[k] = v
+				}
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+
+			certPEM, err := api.signCSR(req.Binding.CSR)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			// region is not modeled by operatorResponse
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(struct {
+				operatorResponse
+				Region string `json:"region"`
+			}{
+				operatorResponse: operatorResponse{
+					ID:       fmt.Sprintf("k8sop_%d", len(api.created)),
+					Metadata: req.Metadata,
+					Binding:  &operatorBinding{Cert: operatorCert{Cert: certPEM}},
+				},
+				Region: req.Region,
+			})
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/kubernetes_operators/"):
+			var req operatorUpdateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Binding == nil {
+				http.Error(w, "missing binding", http.StatusBadRequest)
+				return
+			}
+
+			certPEM, err := api.signCSR(req.Binding.CSR)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			json.NewEncoder(w).Encode(operatorResponse{
+				ID:      strings.TrimPrefix(r.URL.Path, "/kubernetes_operators/"),
+				Binding: &operatorBinding{Cert: operatorCert{Cert: certPEM}},
+			})
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/kubernetes_operators/"):
+			id := strings.TrimPrefix(r.URL.Path, "/kubernetes_operators/")
+			if !api.operatorExists(id) {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(operatorResponse{ID: id})
+		case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/kubernetes_operators/"):
+			api.deleted = append(api.deleted, strings.TrimPrefix(r.URL.Path, "/kubernetes_operators/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+// Created returns the operator create requests received so far.
+func (a *testAPI) Created() []operatorCreateRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]operatorCreateRequest(nil), a.created...)
+}
+
+// operatorExists reports whether id was created and not deleted. Called with
+// the API lock held.
+func (a *testAPI) operatorExists(id string) bool {
+	for _, deleted := range a.deleted {
+		if deleted == id {
+			return false
+		}
+	}
+	for i := range a.created {
+		if id == fmt.Sprintf("k8sop_%d", i+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deleted returns the IDs of operators deleted so far.
+func (a *testAPI) Deleted() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.deleted...)
+}
+
+// signCSR issues a client certificate for a PEM CSR, signed by the test CA.
+func (a *testAPI) signCSR(csrPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil {
+		return "", fmt.Errorf("invalid CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	publicKey := csr.PublicKey
+	if a.signKey != nil {
+		publicKey = a.signKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, a.ca, publicKey, a.caKey)
+	if err != nil {
+		return "", err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if a.chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.ca.Raw})...)
+	}
+	return string(certPEM), nil
+}
+
+// newTestProvisioner creates a certProvisioner backed by api and store.
+func newTestProvisioner(api *testAPI, store CertStore) *certProvisioner {
+	client := newAPIClient("test-api-key")
+	client.baseURL = api.URL
+	cfg := Config{CertStore: store}
+	cfg.setDefaults()
+	return newCertProvisioner(cfg, client)
+}
+
+// SetEndpoints replaces the bound endpoints served by the API.
+func (a *testAPI) SetEndpoints(endpoints ...apiEndpoint) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.endpoints = endpoints
+}
+
+// Calls returns how many times method and path were requested.
+func (a *testAPI) Calls(method, path string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls[method+" "+path]
+}
+
+// newTestDiscoveryDialer creates a discovery dialer backed by api, with a test
+// certificate and operator so no provisioning happens.
+func newTestDiscoveryDialer(t *testing.T, api *testAPI, cfg Config) *discoveryDialer {
+	t.Helper()
+
+	if cfg.APIKey == "" {
+		cfg.APIKey = "test-api-key"
+	}
+	if cfg.Cert.Certificate == nil {
+		cfg.Cert = generateTestCert(t)
+	}
+	if cfg.OperatorID == "" {
+		cfg.OperatorID = "k8sop_test"
+	}
+
+	d, err := DiscoveryDialer(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("failed to create discovery dialer: %v", err)
+	}
+	d.apiClient.baseURL = api.URL
+
+	return d
+}
+
+func TestDiscoveryMinInterval(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, apiEndpoint{ID: "ep_1", URL: "http://app.example", Proto: "http"})
+	d := newTestDiscoveryDialer(t, api, Config{MinDiscoverInterval: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		endpoints, err := d.Endpoints(ctx)
+		if err != nil {
+			t.Fatalf("Endpoints failed: %v", err)
+		}
+		if len(endpoints) != 1 {
+			t.Fatalf("expected 1 endpoint, got %d", len(endpoints))
+		}
+	}
+
+	boundPath := "/kubernetes_operators/k8sop_test/bound_endpoints"
+	if n := api.Calls("GET", boundPath); n != 1 {
+		t.Errorf("expected 1 API call within the interval, got %d", n)
+	}
+
+	if _, err := d.ForceRefresh(ctx); err != nil {
+		t.Fatalf("ForceRefresh failed: %v", err)
+	}
+	if n := api.Calls("GET", boundPath); n != 2 {
+		t.Errorf("expected ForceRefresh to bypass the interval, got %d calls", n)
+	}
+}
+
+func TestWaitForEndpoint(t *testing.T) {
+	api := newTestAPI(t)
+	api.beforeList = func(call int) {
+		if call == 2 {
+			api.endpoints = []apiEndpoint{{ID: "ep_1", URL: "http://app.example", Proto: "http"}}
+		}
+	}
+	d := newTestDiscoveryDialer(t, api, Config{})
+	d.waitInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ep, err := d.WaitForEndpoint(ctx, "app.example")
+	if err != nil {
+		t.Fatalf("WaitForEndpoint failed: %v", err)
+	}
+	if ep.ID != "ep_1" {
+		t.Errorf("expected ep_1, got %s", ep.ID)
+	}
+	if n := api.Calls("GET", "/kubernetes_operators/k8sop_test/bound_endpoints"); n != 2 {
+		t.Errorf("expected endpoint on the second discovery, got %d discoveries", n)
+	}
+}
+
+func TestWaitForEndpointContextDone(t *testing.T) {
+	api := newTestAPI(t)
+	d := newTestDiscoveryDialer(t, api, Config{})
+	d.waitInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := d.WaitForEndpoint(ctx, "missing.example"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestOperatorMetadataRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	metadata := map[string]any{
+		"type": "sdk",
+		"owner": map[string]any{
+			"team":  "payments",
+			"reap":  true,
+			"order": []any{float64(1), float64(2)},
+		},
+	}
+	d, err := DiscoveryDialer(ctx, Config{
+		APIKey:           "test-api-key",
+		CertStore:        NewMemoryStore(),
+		OperatorMetadata: metadata,
+		LazyProvision:    true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create discovery dialer: %v", err)
+	}
+	d.apiClient.baseURL = api.URL
+
+	var got map[string]any
+	if err := d.OperatorMetadata(&got); err != nil || got != nil {
+		t.Fatalf("expected no metadata before provisioning, got %v (%v)", got, err)
+	}
+
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+
+	// The fake API echoes metadata back, so decoding its response completes the round trip
+	if err := d.OperatorMetadata(&got); err != nil {
+		t.Fatalf("OperatorMetadata failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, metadata) {
+		t.Errorf("metadata mismatch: got %v, want %v", got, metadata)
+	}
+}
+
+func TestOperatorRaw(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	p := newTestProvisioner(api, NewMemoryStore())
+	if _, _, err := p.EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	var raw struct {
+		ID     string `json:"id"`
+		Region string `json:"region"`
+	}
+	if err := json.Unmarshal(p.operatorRaw, &raw); err != nil {
+		t.Fatalf("raw response is not JSON: %v", err)
+	}
+	if raw.ID != "k8sop_1" || raw.Region != "global" {
+		t.Errorf("expected raw response with id and unmodeled region, got %s", p.operatorRaw)
+	}
+
+	// Loading the stored identity does not create an operator, so there is no response
+	p2 := newTestProvisioner(api, p.store)
+	if _, _, err := p2.EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate from store failed: %v", err)
+	}
+	if p2.operatorRaw != nil {
+		t.Errorf("expected no raw response for a stored identity, got %s", p2.operatorRaw)
+	}
+}
+
+func TestDiscoveryDialerClone(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, apiEndpoint{ID: "ep_1", URL: "http://app.example", Proto: "http"})
+	d := newTestDiscoveryDialer(t, api, Config{MinDiscoverInterval: time.Hour})
+
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	clone, err := d.Clone(func(cfg *Config) {
+		cfg.DenyHosts = []string{"app.example"}
+	})
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if clone.OperatorID() != d.OperatorID() {
+		t.Errorf("expected clone operator %s, got %s", d.OperatorID(), clone.OperatorID())
+	}
+	if n := len(api.Created()); n != 0 {
+		t.Errorf("expected clone not to provision, got %d creates", n)
+	}
+
+	// The clone inherits the discovered endpoints, so no API call is needed
+	endpoints, err := clone.Endpoints(ctx)
+	if err != nil {
+		t.Fatalf("clone Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 1 {
+		t.Errorf("expected 1 inherited endpoint, got %d", len(endpoints))
+	}
+	if n := api.Calls("GET", "/kubernetes_operators/k8sop_test/bound_endpoints"); n != 1 {
+		t.Errorf("expected 1 discovery, got %d", n)
+	}
+
+	var denied *HostDeniedError
+	if _, err := clone.Dial("tcp", "app.example:80"); !errors.As(err, &denied) {
+		t.Errorf("expected clone to honor DenyHosts, got %v", err)
+	}
+	if len(d.denyHosts) != 0 {
+		t.Errorf("expected original DenyHosts to be unchanged, got %v", d.denyHosts)
+	}
+}
+
+func TestConfigSummary(t *testing.T) {
+	api := newTestAPI(t)
+	d := newTestDiscoveryDialer(t, api, Config{APIKey: "secret-api-key"})
+
+	summary := d.ConfigSummary()
+
+	for _, want := range []string{
+		"ingress_endpoint: " + defaultIngressEndpoint,
+		"ingress_tls_verify: false",
+		"cert_store: *ngrokd.FileStore",
+		"api_key: [redacted]",
+		"operator_id: k8sop_test",
+		"endpoint_selectors: [true]",
+		"cert_not_after: ",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected summary to contain %q, got:\n%s", want, summary)
+		}
+	}
+
+	if strings.Contains(summary, "secret-api-key") {
+		t.Error("summary must not contain the API key")
+	}
+	if strings.Contains(summary, "PRIVATE KEY") {
+		t.Error("summary must not contain key material")
+	}
+}
+
+func TestAPIRedirectSameHost(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	var auth string
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved/kubernetes_operators" {
+			http.Redirect(w, r, "/kubernetes_operators", http.StatusTemporaryRedirect)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		// Serve the real handler for the redirected request
+		api.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer redirector.Close()
+
+	client := newAPIClient("test-api-key")
+	client.baseURL = redirector.URL + "/moved"
+
+	p := newTestProvisioner(api, NewMemoryStore())
+	p.apiClient = client
+
+	if _, _, err := p.EnsureCertificate(ctx); err != nil {
+		t.Fatalf("expected same-host 307 to be followed, got %v", err)
+	}
+	if auth != "Bearer test-api-key" {
+		t.Errorf("expected Authorization on redirected request, got %q", auth)
+	}
+	if n := len(api.Created()); n != 1 {
+		t.Errorf("expected 1 operator created after redirect, got %d", n)
+	}
+}
+
+func TestAPIKeyProvider(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var auths []string
+	revoked := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		if strings.HasSuffix(r.URL.Path, "/bound_endpoints") {
+			auths = append(auths, auth)
+		}
+		if revoked[auth] {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"endpoints": []apiEndpoint{}})
+	}))
+	defer srv.Close()
+
+	var key atomic.Value
+	key.Store("key-1")
+	var calls atomic.Int32
+	client := newAPIClient("static-key")
+	client.baseURL = srv.URL
+	client.keyProvider = func(context.Context) (string, error) {
+		calls.Add(1)
+		return key.Load().(string), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ListBoundEndpoints(ctx, "k8sop_test"); err != nil {
+			t.Fatalf("ListBoundEndpoints failed: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the provided key to be cached, got %d provider calls", n)
+	}
+
+	// Rotate the key; the API rejecting the old one drops it from the cache
+	key.Store("key-2")
+	mu.Lock()
+	revoked["Bearer key-1"] = true
+	mu.Unlock()
+	if _, err := client.ListBoundEndpoints(ctx, "k8sop_test"); err == nil {
+		t.Fatal("expected the revoked key to be rejected")
+	}
+	if _, err := client.ListBoundEndpoints(ctx, "k8sop_test"); err != nil {
+		t.Fatalf("expected the rotated key to be used, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"Bearer key-1", "Bearer key-1", "Bearer key-1", "Bearer key-2"}
+	if !reflect.DeepEqual(auths, want) {
+		t.Errorf("expected Authorization headers %v, got %v", want, auths)
+	}
+
+	// Provider errors fail the request before it is sent
+	client.keyProvider = func(context.Context) (string, error) { return "", errors.New("vault sealed") }
+	client.cachedKey = ""
+	if _, err := client.ListBoundEndpoints(ctx, "k8sop_test"); err == nil || !strings.Contains(err.Error(), "vault sealed") {
+		t.Errorf("expected the provider error, got %v", err)
+	}
+}
+
+func TestAPIRedirectRefused(t *testing.T) {
+	ctx := context.Background()
+
+	var otherHits int
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherHits++
+	}))
+	defer other.Close()
+
+	tests := []struct {
+		name     string
+		location string
+		status   int
+	}{
+		{"cross host", other.URL + "/kubernetes_operators", http.StatusTemporaryRedirect},
+		{"method change", "/kubernetes_operators/elsewhere", http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "POST" {
+					http.Redirect(w, r, tt.location, tt.status)
+					return
+				}
+				t.Errorf("unexpected %s %s after refused redirect", r.Method, r.URL.Path)
+			}))
+			defer srv.Close()
+
+			client := newAPIClient("test-api-key")
+			client.baseURL = srv.URL
+
+			if _, err := client.CreateOperator(ctx, &operatorCreateRequest{}); err == nil {
+				t.Fatal("expected redirect to be refused")
+			}
+		})
+	}
+
+	if otherHits != 0 {
+		t.Errorf("expected no requests to the other host, got %d", otherHits)
+	}
+}
+
+func TestDialTCPPortFromDiscovery(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+	api := newTestAPI(t, apiEndpoint{ID: "ep_db", URL: "tcp://app.ns:5432", Proto: "tcp"})
+	d := newTestDiscoveryDialer(t, api, Config{IngressEndpoint: ingress.addr})
+
+	if _, err := d.DialContext(ctx, "tcp", "tcp://app.ns"); !errors.Is(err, errPortRequired) {
+		t.Fatalf("expected port error before discovery, got %v", err)
+	}
+
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", "tcp://app.ns")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	reqs := ingress.Requests()
+	if len(reqs) != 1 || reqs[0].Host != "app.ns" || reqs[0].Port != 5432 {
+		t.Errorf("expected binding request for app.ns:5432, got %+v", reqs)
+	}
+
+	if _, err := d.DialContext(ctx, "tcp", "tcp://unknown.ns"); !errors.Is(err, errPortRequired) {
+		t.Errorf("expected port error for unknown host, got %v", err)
+	}
+
+	// tls defaults to 443 rather than borrowing the tcp endpoint's port
+	conn, err = d.DialContext(ctx, "tcp", "tls://app.ns")
+	if err != nil {
+		t.Fatalf("tls dial failed: %v", err)
+	}
+	conn.Close()
+	if reqs := ingress.Requests(); len(reqs) != 2 || reqs[1].Port != 443 {
+		t.Errorf("expected tls:// without port to bind app.ns:443, got %+v", reqs)
+	}
+}
+
+func TestDialDerivedPortFromDiscovery(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+	api := newTestAPI(t, apiEndpoint{ID: "ep_app", URL: "http://app.ns:8080", Proto: "http"})
+	d := newTestDiscoveryDialer(t, api, Config{IngressEndpoint: ingress.addr})
+
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	// A scheme-derived port gives way to the discovered one; explicit ports
+	// and bare hostnames are dialed as given
+	for _, address := range []string{"http://app.ns", "http://app.ns:80", "app.ns", "http://other.ns"} {
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", address, err)
+		}
+		conn.Close()
+	}
+
+	var ports []int
+	for _, req := range ingress.Requests() {
+		ports = append(ports, req.Port)
+	}
+	if want := []int{8080, 80, 80, 80}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("expected bound ports %v, got %v", want, ports)
+	}
+}
+
+func TestStaticEndpoints(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+	api := newTestAPI(t, apiEndpoint{ID: "ep_app", URL: "http://app.ns", Proto: "http"})
+	d := newTestDiscoveryDialer(t, api, Config{
+		IngressEndpoint: ingress.addr,
+		StaticEndpoints: []Endpoint{{ID: "ep_db", URL: &url.URL{Scheme: "TCP", Host: "DB.ns:5432"}}},
+	})
+
+	conn, err := d.DialContext(ctx, "tcp", "tcp://db.ns")
+	if err != nil {
+		t.Fatalf("dial of static endpoint before discovery failed: %v", err)
+	}
+	conn.Close()
+	if reqs := ingress.Requests(); len(reqs) != 1 || reqs[0].Host != "db.ns" || reqs[0].Port != 5432 {
+		t.Errorf("expected binding request for db.ns:5432, got %+v", reqs)
+	}
+	if n := api.Calls("GET", "/kubernetes_operators/"+d.OperatorID()+"/bound_endpoints"); n != 0 {
+		t.Errorf("expected no discovery before the first Endpoints call, got %d", n)
+	}
+
+	endpoints, err := d.Endpoints(ctx)
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	var ids []string
+	for _, ep := range endpoints {
+		ids = append(ids, ep.ID)
+	}
+	if len(ids) != 2 || ids[0] != "ep_app" || ids[1] != "ep_db" {
+		t.Errorf("expected discovered and static endpoints, got %v", ids)
+	}
+
+	if _, err := d.DialContext(ctx, "tcp", "tcp://db.ns"); err != nil {
+		t.Errorf("dial of static endpoint after discovery failed: %v", err)
+	}
+}
+
+func TestConfigRejectsInvalidStaticEndpoint(t *testing.T) {
+	cfg := Config{APIKey: "test-api-key", StaticEndpoints: []Endpoint{{ID: "ep_bad"}}}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected error for static endpoint without a URL")
+	}
+}
+
+func TestListBoundEndpointsConditional(t *testing.T) {
+	ctx := context.Background()
+
+	var conditional, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kubernetes_operators/k8sop_test/bound_endpoints":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				conditional++
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			json.NewEncoder(w).Encode(map[string]any{"endpoints": []apiEndpoint{
+				{ID: "ep_1", URL: "http://app.example", Proto: "http"},
+			}})
+		case "/endpoints":
+			json.NewEncoder(w).Encode(map[string]any{"endpoints": []map[string]any{
+				{"id": "ep_1", "bindings": []string{"kubernetes"}},
+			}})
+		}
+	}))
+	defer srv.Close()
+
+	client := newAPIClient("test-api-key")
+	client.baseURL = srv.URL
+
+	for i := 0; i < 2; i++ {
+		endpoints, err := client.ListBoundEndpoints(ctx, "k8sop_test")
+		if err != nil {
+			t.Fatalf("ListBoundEndpoints #%d failed: %v", i+1, err)
+		}
+		if len(endpoints) != 1 || endpoints[0].ID != "ep_1" {
+			t.Fatalf("ListBoundEndpoints #%d: unexpected endpoints %+v", i+1, endpoints)
+		}
+	}
+
+	if conditional != 1 || notModified != 1 {
+		t.Errorf("expected second listing to be served from a 304, got %d conditional requests", conditional)
+	}
+}
+
+func TestDiscoveryNormalizesEndpointURLs(t *testing.T) {
+	api := newTestAPI(t,
+		apiEndpoint{ID: "ep_1", URL: "http://App.Example", Proto: "http"},
+		apiEndpoint{ID: "ep_1", URL: "http://app.example:80/", Proto: "http"},
+		apiEndpoint{ID: "ep_1", URL: "HTTP://APP.EXAMPLE/", Proto: "http"},
+		apiEndpoint{ID: "ep_2", URL: "https://app.example:443", Proto: "https"},
+	)
+	d := newTestDiscoveryDialer(t, api, Config{})
+
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	var urls []string
+	for _, ep := range endpoints {
+		urls = append(urls, ep.URL.String())
+	}
+	want := []string{"http://app.example", "https://app.example"}
+	if !reflect.DeepEqual(urls, want) {
+		t.Errorf("expected normalized endpoints %v, got %v", want, urls)
+	}
+}
+
+func TestVerifyOnDiscover(t *testing.T) {
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		if req.Host == "down.example" {
+			return testBindingResponse{ErrorCode: "ERR_NGROK_3004", ErrorMessage: "backend unavailable"}
+		}
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http"}
+	})
+	api := newTestAPI(t,
+		apiEndpoint{ID: "ep_up", URL: "http://up.example", Proto: "http"},
+		apiEndpoint{ID: "ep_down", URL: "http://down.example", Proto: "http"},
+	)
+	d := newTestDiscoveryDialer(t, api, Config{IngressEndpoint: ingress.addr, VerifyOnDiscover: true})
+
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	reachable := map[string]bool{}
+	for _, ep := range endpoints {
+		reachable[ep.ID] = ep.Reachable
+	}
+	want := map[string]bool{"ep_up": true, "ep_down": false}
+	if !reflect.DeepEqual(reachable, want) {
+		t.Errorf("expected reachability %v, got %v", want, reachable)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+	store := NewMemoryStore()
+	d := newTestDiscoveryDialer(t, api, Config{CertStore: store})
+
+	before, _, _, err := d.ExportIdentity()
+	if err != nil {
+		t.Fatalf("ExportIdentity failed: %v", err)
+	}
+
+	if err := d.RotateKey(ctx); err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+
+	after, _, opID, err := d.ExportIdentity()
+	if err != nil {
+		t.Fatalf("ExportIdentity failed: %v", err)
+	}
+	if opID != "k8sop_test" {
+		t.Errorf("expected operator ID to be unchanged, got %s", opID)
+	}
+	if bytes.Equal(before, after) {
+		t.Error("expected a new certificate after rotation")
+	}
+
+	_, storedCert, storedID, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("store Load failed: %v", err)
+	}
+	if storedID != "k8sop_test" || !bytes.Equal(storedCert, after) {
+		t.Errorf("expected rotated identity to be saved for k8sop_test, got operator %s", storedID)
+	}
+
+	if n := api.Calls("PATCH", "/kubernetes_operators/k8sop_test"); n != 1 {
+		t.Errorf("expected 1 operator update, got %d", n)
+	}
+	if n := len(api.Created()); n != 0 {
+		t.Errorf("expected no new operators, got %d", n)
+	}
+}
+
+// countingStore counts every call made to the wrapped CertStore.
+type countingStore struct {
+	CertStore
+	calls atomic.Int32
+}
+
+func (s *countingStore) Load(ctx context.Context) (key, cert []byte, operatorID string, err error) {
+	s.calls.Add(1)
+	return s.CertStore.Load(ctx)
+}
+
+func (s *countingStore) Save(ctx context.Context, key, cert []byte, operatorID string) error {
+	s.calls.Add(1)
+	return s.CertStore.Save(ctx, key, cert, operatorID)
+}
+
+func (s *countingStore) Exists(ctx context.Context) (bool, error) {
+	s.calls.Add(1)
+	return s.CertStore.Exists(ctx)
+}
+
+func (s *countingStore) CanWrite(ctx context.Context) error {
+	s.calls.Add(1)
+	return s.CertStore.CanWrite(ctx)
+}
+
+func TestCertPreference(t *testing.T) {
+	supplied := generateTestCert(t)
+	storedKey, storedCert := encodeTestCert(t, generateTestCert(t))
+
+	tests := []struct {
+		preference     CertPreference
+		wantOperatorID string
+		wantCreated    int
+		wantSupplied   bool
+	}{
+		{preference: "", wantSupplied: true},
+		{preference: CertPreferSupplied, wantSupplied: true},
+		{preference: CertPreferStore, wantOperatorID: "k8sop_stored"},
+		{preference: CertPreferProvision, wantOperatorID: "k8sop_1", wantCreated: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.preference), func(t *testing.T) {
+			ctx := context.Background()
+			api := newTestAPI(t)
+
+			d, err := DiscoveryDialer(ctx, Config{
+				APIKey:         "test-api-key",
+				Cert:           supplied,
+				CertStore:      NewMemoryStoreWithCert(storedKey, storedCert, "k8sop_stored"),
+				CertPreference: tt.preference,
+				LazyProvision:  true,
+			})
+			if err != nil {
+				t.Fatalf("failed to create discovery dialer: %v", err)
+			}
+			d.apiClient.baseURL = api.URL
+
+			if err := d.ensureProvisioned(ctx); err != nil {
+				t.Fatalf("ensureProvisioned failed: %v", err)
+			}
+
+			if id := d.OperatorID(); id != tt.wantOperatorID {
+				t.Errorf("expected operator ID %q, got %q", tt.wantOperatorID, id)
+			}
+			if n := len(api.Created()); n != tt.wantCreated {
+				t.Errorf("expected %d CreateOperator calls, got %d", tt.wantCreated, n)
+			}
+			usedSupplied := bytes.Equal(d.clientTLSConfig().Certificates[0].Certificate[0], supplied.Certificate[0])
+			if usedSupplied != tt.wantSupplied {
+				t.Errorf("expected supplied cert used = %t, got %t", tt.wantSupplied, usedSupplied)
+			}
+		})
+	}
+}
+
+func TestCertPreferStoreFallsBackToSupplied(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+	supplied := generateTestCert(t)
+
+	d, err := DiscoveryDialer(ctx, Config{
+		APIKey:         "test-api-key",
+		Cert:           supplied,
+		CertStore:      NewMemoryStore(),
+		CertPreference: CertPreferStore,
+		LazyProvision:  true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create discovery dialer: %v", err)
+	}
+	d.apiClient.baseURL = api.URL
+
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+	if !bytes.Equal(d.clientTLSConfig().Certificates[0].Certificate[0], supplied.Certificate[0]) {
+		t.Error("expected the supplied cert with an empty store")
+	}
+	if n := len(api.Created()); n != 0 {
+		t.Errorf("expected no CreateOperator calls, got %d", n)
+	}
+}
+
+func TestConfigValidatesCertPreference(t *testing.T) {
+	cfg := Config{APIKey: "test-api-key", CertPreference: "newest"}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected error for an unknown CertPreference")
+	}
+
+	cfg = Config{CertPreference: CertPreferProvision}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected error for CertPreferProvision without APIKey")
+	}
+}
+
+func TestCertRevalidateInterval(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	d, err := DiscoveryDialer(ctx, Config{
+		APIKey:                 "test-api-key",
+		CertStore:              NewMemoryStore(),
+		CertRevalidateInterval: 50 * time.Millisecond,
+		LazyProvision:          true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create discovery dialer: %v", err)
+	}
+	d.apiClient.baseURL = api.URL
+
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+	if id := d.OperatorID(); id != "k8sop_1" {
+		t.Fatalf("expected k8sop_1, got %s", id)
+	}
+	initial := d.clientTLSConfig().Certificates[0].Certificate[0]
+
+	// Within the interval the operator is not checked
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+	if n := api.Calls("GET", "/kubernetes_operators/k8sop_1"); n != 0 {
+		t.Errorf("expected no revalidation within the interval, got %d", n)
+	}
+
+	// An existing operator is kept
+	time.Sleep(60 * time.Millisecond)
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+	if n := api.Calls("GET", "/kubernetes_operators/k8sop_1"); n != 1 {
+		t.Errorf("expected one revalidation, got %d", n)
+	}
+	if id := d.OperatorID(); id != "k8sop_1" {
+		t.Errorf("expected operator to be kept, got %s", id)
+	}
+
+	// A deleted operator is replaced mid-life of its certificate
+	if err := d.apiClient.DeleteOperator(ctx, "k8sop_1"); err != nil {
+		t.Fatalf("DeleteOperator failed: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+	if id := d.OperatorID(); id != "k8sop_2" {
+		t.Errorf("expected re-provisioned operator k8sop_2, got %s", id)
+	}
+	if bytes.Equal(d.clientTLSConfig().Certificates[0].Certificate[0], initial) {
+		t.Error("expected a new certificate after re-provisioning")
+	}
+}
+
+func TestCertRevalidateMissingOperatorError(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	d, err := DiscoveryDialer(ctx, Config{
+		APIKey:                 "test-api-key",
+		CertStore:              NewMemoryStore(),
+		CertRevalidateInterval: 10 * time.Millisecond,
+		MissingOperatorPolicy:  MissingOperatorError,
+		LazyProvision:          true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create discovery dialer: %v", err)
+	}
+	d.apiClient.baseURL = api.URL
+
+	if err := d.ensureProvisioned(ctx); err != nil {
+		t.Fatalf("ensureProvisioned failed: %v", err)
+	}
+	if err := d.apiClient.DeleteOperator(ctx, "k8sop_1"); err != nil {
+		t.Fatalf("DeleteOperator failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// Every use fails once the deletion is seen, without re-provisioning
+	for i := 0; i < 2; i++ {
+		if err := d.ensureProvisioned(ctx); !errors.Is(err, ErrOperatorNotFound) {
+			t.Fatalf("expected ErrOperatorNotFound, got %v", err)
+		}
+	}
+	if n := len(api.Created()); n != 1 {
+		t.Errorf("expected no re-provisioning, got %d creates", n)
+	}
+}
+
+func TestLazyProvision(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, apiEndpoint{ID: "ep_1", URL: "http://app.example", Proto: "http"})
+	store := &countingStore{CertStore: NewMemoryStore()}
+
+	d, err := DiscoveryDialer(ctx, Config{APIKey: "test-api-key", CertStore: store, LazyProvision: true})
+	if err != nil {
+		t.Fatalf("failed to create discovery dialer: %v", err)
+	}
+	d.apiClient.baseURL = api.URL
+
+	if n := store.calls.Load(); n != 0 {
+		t.Errorf("expected no store calls at construction, got %d", n)
+	}
+	if n := len(api.Created()); n != 0 {
+		t.Errorf("expected no API calls at construction, got %d creates", n)
+	}
+	if id := d.OperatorID(); id != "" {
+		t.Errorf("expected no operator before first use, got %s", id)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.Endpoints(ctx); err != nil {
+				t.Errorf("Endpoints failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(api.Created()); n != 1 {
+		t.Errorf("expected concurrent first use to provision once, got %d creates", n)
+	}
+	if id := d.OperatorID(); id != "k8sop_1" {
+		t.Errorf("expected operator k8sop_1 after first use, got %q", id)
+	}
+}
+
+func TestEndpointMergePolicy(t *testing.T) {
+	api := newTestAPI(t,
+		apiEndpoint{ID: "ep_old", URL: "http://app.example", Proto: "http"},
+		apiEndpoint{ID: "ep_new", URL: "http://app.example/", Proto: "http", Metadata: `{"team":"payments"}`},
+	)
+
+	d := newTestDiscoveryDialer(t, api, Config{})
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].ID != "ep_old" {
+		t.Errorf("expected default policy to keep the first endpoint, got %+v", endpoints)
+	}
+
+	d = newTestDiscoveryDialer(t, api, Config{
+		EndpointMergePolicy: func(existing, incoming Endpoint) Endpoint {
+			if existing.Metadata == "" {
+				return incoming
+			}
+			return existing
+		},
+	})
+	endpoints, err = d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].ID != "ep_new" || endpoints[0].Metadata == "" {
+		t.Errorf("expected custom policy to prefer the endpoint with metadata, got %+v", endpoints)
+	}
+}
+
+func TestDiscoveryDedupByID(t *testing.T) {
+	api := newTestAPI(t,
+		apiEndpoint{ID: "ep_blue", URL: "http://app.example", Proto: "http"},
+		apiEndpoint{ID: "ep_green", URL: "http://app.example", Proto: "http"},
+		apiEndpoint{ID: "ep_blue", URL: "http://app.example", Proto: "http"},
+	)
+
+	d := newTestDiscoveryDialer(t, api, Config{})
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 1 {
+		t.Errorf("expected same-URL endpoints to be collapsed by default, got %+v", endpoints)
+	}
+
+	d = newTestDiscoveryDialer(t, api, Config{DedupByID: true})
+	endpoints, err = d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 2 || endpoints[0].ID != "ep_blue" || endpoints[1].ID != "ep_green" {
+		t.Errorf("expected both same-URL endpoints under DedupByID, got %+v", endpoints)
+	}
+}
+
+func TestOnCacheEmpty(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	var calls int
+	d := newTestDiscoveryDialer(t, api, Config{OnCacheEmpty: func() { calls++ }})
+
+	// Initially empty: no transition
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no call for an initially empty cache, got %d", calls)
+	}
+
+	api.SetEndpoints(apiEndpoint{ID: "ep_1", URL: "http://app.example", Proto: "http"})
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	api.SetEndpoints()
+	for i := 0; i < 2; i++ {
+		if _, err := d.Endpoints(ctx); err != nil {
+			t.Fatalf("Endpoints failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected exactly one call for the non-empty to empty transition, got %d", calls)
+	}
+}
+
+func TestOnCacheEmptyCallsDialer(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t, apiEndpoint{ID: "ep_1", URL: "http://app.example", Proto: "http"})
+
+	var d *discoveryDialer
+	hookErr := make(chan error, 1)
+	d = newTestDiscoveryDialer(t, api, Config{OnCacheEmpty: func() {
+		_, err := d.Endpoints(ctx)
+		hookErr <- err
+	}})
+
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	api.SetEndpoints()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Endpoints(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Endpoints deadlocked on the OnCacheEmpty hook")
+	}
+	if err := <-hookErr; err != nil {
+		t.Errorf("Endpoints from the hook failed: %v", err)
+	}
+}
+
+func TestResetSessionCache(t *testing.T) {
+	api := newTestAPI(t)
+	d := newTestDiscoveryDialer(t, api, Config{CertStore: NewMemoryStore()})
+
+	initial := d.clientTLSConfig().ClientSessionCache
+
+	d.ResetSessionCache()
+	reset := d.clientTLSConfig().ClientSessionCache
+	if reset == initial {
+		t.Error("expected ResetSessionCache to install a new cache")
+	}
+
+	if err := d.RotateKey(context.Background()); err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	if d.clientTLSConfig().ClientSessionCache == reset {
+		t.Error("expected RotateKey to install a new cache")
+	}
+}
+
+func TestVerifyOnDiscoverBulkDialConcurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http"}
+	})
+
+	var eps []apiEndpoint
+	for i := 0; i < 10; i++ {
+		eps = append(eps, apiEndpoint{ID: fmt.Sprintf("ep_%d", i), URL: fmt.Sprintf("http://app%d.example", i), Proto: "http"})
+	}
+	api := newTestAPI(t, eps...)
+	d := newTestDiscoveryDialer(t, api, Config{
+		IngressEndpoint:     ingress.addr,
+		VerifyOnDiscover:    true,
+		BulkDialConcurrency: 2,
+	})
+
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	for _, ep := range endpoints {
+		if !ep.Reachable {
+			t.Errorf("expected %s to be reachable", ep.ID)
+		}
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("expected at most 2 concurrent probes, got %d", p)
+	}
+}
+
+func TestTrustOperatorChain(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+	api.chain = true
+
+	// The ingress serves a certificate issued by the same CA as the operator
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ingress"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, api.ca, &key.PublicKey, api.caKey)
+	if err != nil {
+		t.Fatalf("failed to create cert: %v", err)
+	}
+	trusted := newTestIngressWithCert(t, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil)
+	untrusted := newTestIngress(t, nil)
+
+	newDialer := func(ingressEndpoint string) *discoveryDialer {
+		t.Helper()
+		d, err := DiscoveryDialer(ctx, Config{
+			APIKey:             "test-api-key",
+			CertStore:          NewMemoryStore(),
+			IngressEndpoint:    ingressEndpoint,
+			TrustOperatorChain: true,
+			LazyProvision:      true,
+		})
+		if err != nil {
+			t.Fatalf("failed to create discovery dialer: %v", err)
+		}
+		d.apiClient.baseURL = api.URL
+		if err := d.ensureProvisioned(ctx); err != nil {
+			t.Fatalf("ensureProvisioned failed: %v", err)
+		}
+		return d
+	}
+
+	d := newDialer(trusted.addr)
+	pool := d.IngressCAPool()
+	if pool == nil {
+		t.Fatal("expected verification to be enabled by the operator chain")
+	}
+	if _, err := api.ca.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("expected the operator CA in the pool: %v", err)
+	}
+	conn, err := d.connectIngress(ctx)
+	if err != nil {
+		t.Fatalf("expected verified handshake to succeed, got %v", err)
+	}
+	conn.Close()
+
+	d = newDialer(untrusted.addr)
+	var handshakeErr *TLSHandshakeError
+	if _, err := d.connectIngress(ctx); !errors.As(err, &handshakeErr) {
+		t.Errorf("expected TLSHandshakeError for an ingress outside the chain, got %v", err)
+	}
+}
@@ -2,23 +2,46 @@ package ngrokd
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
 )
 
+// ConnRequest is the binding request sent to the ingress to bind a
+// connection to an endpoint.
+type ConnRequest struct {
+	Host string
+	Port int
+}
+
+// maxCapturedFrame bounds each frame attached to a BindingProtocolError.
+const maxCapturedFrame = 4096
+
 // upgradeToBinding upgrades a connection using the binding protocol.
-// Returns endpointID and proto on success.
-func upgradeToBinding(conn net.Conn, host string, port int) (endpointID, proto string, err error) {
-	if err := writeBindingRequest(conn, host, port); err != nil {
+// Returns endpointID and proto on success. If capture is set, a response
+// that fails to decode is returned with both frames attached.
+func upgradeToBinding(conn net.Conn, req ConnRequest, capture bool) (endpointID, proto string, err error) {
+	request := marshalBindingRequest(req)
+	if _, err := conn.Write(request); err != nil {
 		return "", "", fmt.Errorf("failed to write request: %w", err)
 	}
 
-	endpointID, proto, errorCode, errorMessage, err := readBindingResponse(conn)
+	response, err := readBindingFrame(conn)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to read response: %w", err)
 	}
 
+	endpointID, proto, errorCode, errorMessage, err := decodeBindingResponse(response[2:])
+	if err != nil {
+		protoErr := &BindingProtocolError{Err: err}
+		if capture {
+			protoErr.Request = captureFrame(request)
+			protoErr.Response = captureFrame(response)
+		}
+		return "", "", protoErr
+	}
+
 	if errorCode != "" || errorMessage != "" {
 		return "", "", fmt.Errorf("binding error [%s]: %s", errorCode, errorMessage)
 	}
@@ -26,41 +49,43 @@ func upgradeToBinding(conn net.Conn, host string, port int) (endpointID, proto s
 	return endpointID, proto, nil
 }
 
-func writeBindingRequest(conn net.Conn, host string, port int) error {
+// marshalBindingRequest encodes req as a length-prefixed frame.
+func marshalBindingRequest(req ConnRequest) []byte {
 	// Manual protobuf encoding
-	var buf []byte
+	buf := []byte{0, 0}
 
-	if host != "" {
+	if req.Host != "" {
 		buf = append(buf, 0x0a)
-		buf = appendVarint(buf, uint64(len(host)))
-		buf = append(buf, host...)
+		buf = appendVarint(buf, uint64(len(req.Host)))
+		buf = append(buf, req.Host...)
 	}
 
-	if port != 0 {
+	if req.Port != 0 {
 		buf = append(buf, 0x10)
-		buf = appendVarint(buf, uint64(port))
+		buf = appendVarint(buf, uint64(req.Port))
 	}
 
-	length := uint16(len(buf))
-	if err := binary.Write(conn, binary.LittleEndian, length); err != nil {
-		return err
-	}
-
-	_, err := conn.Write(buf)
-	return err
+	binary.LittleEndian.PutUint16(buf, uint16(len(buf)-2))
+	return buf
 }
 
-func readBindingResponse(conn net.Conn) (endpointID, proto, errorCode, errorMessage string, err error) {
-	var length uint16
-	if err := binary.Read(conn, binary.LittleEndian, &length); err != nil {
-		return "", "", "", "", err
+// readBindingFrame reads a length-prefixed frame, returned with its prefix.
+func readBindingFrame(conn net.Conn) ([]byte, error) {
+	var prefix [2]byte
+	if _, err := io.ReadFull(conn, prefix[:]); err != nil {
+		return nil, err
 	}
 
-	buf := make([]byte, length)
-	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", "", "", "", err
+	frame := make([]byte, 2+int(binary.LittleEndian.Uint16(prefix[:])))
+	copy(frame, prefix[:])
+	if _, err := io.ReadFull(conn, frame[2:]); err != nil {
+		return nil, err
 	}
 
+	return frame, nil
+}
+
+func decodeBindingResponse(buf []byte) (endpointID, proto, errorCode, errorMessage string, err error) {
 	// Manual protobuf decoding
 	// Field 1: endpointID, 2: proto, 3: errorCode, 4: errorMessage
 	pos := 0
@@ -77,6 +102,9 @@ func readBindingResponse(conn net.Conn) (endpointID, proto, errorCode, errorMess
 		case 2: // length-delimited
 			length, n := consumeVarint(buf[pos:])
 			pos += n
+			if length > uint64(len(buf)-pos) {
+				return "", "", "", "", fmt.Errorf("field %d overruns response", fieldNum)
+			}
 			value := string(buf[pos : pos+int(length)])
 			pos += int(length)
 
@@ -98,6 +126,15 @@ func readBindingResponse(conn net.Conn) (endpointID, proto, errorCode, errorMess
 	return endpointID, proto, errorCode, errorMessage, nil
 }
 
+// captureFrame hex-encodes frame for a BindingProtocolError, truncated to
+// maxCapturedFrame bytes.
+func captureFrame(frame []byte) string {
+	if len(frame) > maxCapturedFrame {
+		frame = frame[:maxCapturedFrame]
+	}
+	return hex.EncodeToString(frame)
+}
+
 func appendVarint(buf []byte, v uint64) []byte {
 	for v >= 0x80 {
 		buf = append(buf, byte(v)|0x80)
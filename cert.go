@@ -2,102 +2,183 @@ package ngrokd
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
 )
 
 type certProvisioner struct {
 	store             CertStore
+	logger            logr.Logger
 	apiClient         *apiClient
+	operatorID        string
 	endpointSelectors []string
+	metadata          map[string]any
+	subject           pkix.Name
+	signatureAlg      x509.SignatureAlgorithm
+	bindingOptions    BindingOptions
+	clockSkew         time.Duration
+	missingOperator   MissingOperatorPolicy
+
+	// csrProvider and externalKey replace key and CSR generation when the
+	// key is managed outside the process.
+	csrProvider func(ctx context.Context) ([]byte, error)
+	externalKey crypto.Signer
+
+	// operatorRaw is the raw create response of the last provisioned operator.
+	operatorRaw json.RawMessage
+}
+
+// defaultCSRSubject is the CSR subject used when Config.CSRSubject is nil.
+var defaultCSRSubject = pkix.Name{
+	Organization: []string{"ngrokd-sdk"},
 }
 
-func newCertProvisioner(store CertStore, apiClient *apiClient, endpointSelectors []string) *certProvisioner {
+// newCertProvisioner creates a provisioner from a Config with defaults applied.
+func newCertProvisioner(cfg Config, apiClient *apiClient) *certProvisioner {
+	subject := defaultCSRSubject
+	if cfg.CSRSubject != nil {
+		subject = *cfg.CSRSubject
+	}
+
+	csrProvider := cfg.CSRProvider
+	if cfg.CSRPEM != nil {
+		csrPEM := cfg.CSRPEM
+		csrProvider = func(context.Context) ([]byte, error) { return csrPEM, nil }
+	}
+
 	return &certProvisioner{
-		store:             store,
+		store:             cfg.CertStore,
+		logger:            cfg.Logger,
 		apiClient:         apiClient,
-		endpointSelectors: endpointSelectors,
+		operatorID:        cfg.OperatorID,
+		endpointSelectors: cfg.EndpointSelectors,
+		metadata:          cfg.OperatorMetadata,
+		subject:           subject,
+		signatureAlg:      cfg.CSRSignatureAlgorithm,
+		bindingOptions:    cfg.BindingOptions,
+		clockSkew:         cfg.ClockSkew,
+		missingOperator:   cfg.MissingOperatorPolicy,
+		csrProvider:       csrProvider,
+		externalKey:       cfg.PrivateKey,
 	}
 }
 
 func (p *certProvisioner) EnsureCertificate(ctx context.Context) (cert tls.Certificate, operatorID string, err error) {
+	cert, operatorID, ok, err := p.loadCertificate(ctx)
+	if err != nil || ok {
+		return cert, operatorID, err
+	}
+
+	// Provision new certificate
+	return p.provisionCertificate(ctx)
+}
+
+// loadCertificate returns the valid certificate held by the store, and reports
+// false if there is none to reuse.
+func (p *certProvisioner) loadCertificate(ctx context.Context) (cert tls.Certificate, operatorID string, ok bool, err error) {
+	// A keyed store can hold the identity of the requested operator
+	if keyed, ok := p.store.(KeyedCertStore); ok && p.operatorID != "" {
+		var keyPEM, certPEM []byte
+		err := p.storeOp("LoadOperator", func() (err error) {
+			keyPEM, certPEM, err = keyed.LoadOperator(ctx, p.operatorID)
+			return err
+		})
+		if err != nil {
+			return tls.Certificate{}, "", false, fmt.Errorf("failed to load operator %s from store: %w", p.operatorID, err)
+		}
+		cert, err = p.keyPair(certPEM, keyPEM)
+		if err != nil {
+			return tls.Certificate{}, "", false, fmt.Errorf("failed to parse certificate for operator %s: %w", p.operatorID, err)
+		}
+		if !certValid(cert, time.Now(), p.clockSkew) {
+			return tls.Certificate{}, "", false, fmt.Errorf("certificate for operator %s is expired or not yet valid", p.operatorID)
+		}
+		ok, err := p.checkStoredOperator(ctx, p.operatorID)
+		if !ok || err != nil {
+			return tls.Certificate{}, "", false, err
+		}
+		return cert, p.operatorID, true, nil
+	}
+
 	// Check if certificate exists in store
-	exists, err := p.store.Exists(ctx)
+	var exists bool
+	err = p.storeOp("Exists", func() (err error) {
+		exists, err = p.store.Exists(ctx)
+		return err
+	})
 	if err != nil {
-		return tls.Certificate{}, "", fmt.Errorf("failed to check store: %w", err)
+		return tls.Certificate{}, "", false, fmt.Errorf("failed to check store: %w", err)
 	}
 
 	if exists {
-		keyPEM, certPEM, opID, err := p.store.Load(ctx)
+		var keyPEM, certPEM []byte
+		var opID string
+		err := p.storeOp("Load", func() (err error) {
+			keyPEM, certPEM, opID, err = p.store.Load(ctx)
+			return err
+		})
 		if err == nil {
-			cert, err = tls.X509KeyPair(certPEM, keyPEM)
-			if err == nil {
-				return cert, opID, nil
+			cert, err = p.keyPair(certPEM, keyPEM)
+			if err == nil && certValid(cert, time.Now(), p.clockSkew) {
+				ok, err := p.checkStoredOperator(ctx, opID)
+				if err != nil {
+					return tls.Certificate{}, "", false, err
+				}
+				if ok {
+					return cert, opID, true, nil
+				}
 			}
 		}
-		// Fall through to provision if load failed
+		// Nothing to reuse if load failed or the cert is expired
 	}
 
-	// Provision new certificate
-	return p.provisionCertificate(ctx)
+	return tls.Certificate{}, "", false, nil
 }
 
 func (p *certProvisioner) provisionCertificate(ctx context.Context) (tls.Certificate, string, error) {
 	// Validate store is writable before creating operator to prevent
 	// orphaned operators on permission errors during crash loops
-	if err := p.store.CanWrite(ctx); err != nil {
+	if err := p.storeOp("CanWrite", func() error { return p.store.CanWrite(ctx) }); err != nil {
 		return tls.Certificate{}, "", fmt.Errorf("certificate store not writable: %w", err)
 	}
 
-	// Generate ECDSA P-384 private key
-	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	metadata, err := json.Marshal(p.metadata)
 	if err != nil {
-		return tls.Certificate{}, "", fmt.Errorf("failed to generate key: %w", err)
+		return tls.Certificate{}, "", fmt.Errorf("failed to encode operator metadata: %w", err)
 	}
 
-	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	privateKey, privateKeyPEM, csrPEM, err := p.keyAndCSR(ctx)
 	if err != nil {
 		return tls.Certificate{}, "", err
 	}
 
-	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: privateKeyBytes,
-	})
-
-	// Create CSR
-	template := x509.CertificateRequest{
-		Subject: pkix.Name{
-			Organization: []string{"ngrokd-sdk"},
-		},
-		SignatureAlgorithm: x509.ECDSAWithSHA384,
-	}
-
-	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
-	if err != nil {
-		return tls.Certificate{}, "", err
+	region := p.bindingOptions.Region
+	if region == "" {
+		region = "global"
 	}
 
-	csrPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE REQUEST",
-		Bytes: csrDER,
-	})
-
 	// Register with ngrok API
 	operator, err := p.apiClient.CreateOperator(ctx, &operatorCreateRequest{
 		Description:     "ngrokd-sdk",
-		Metadata:        `{"type":"sdk"}`,
+		Metadata:        string(metadata),
 		EnabledFeatures: []string{"bindings"},
-		Region:          "global",
+		Region:          region,
 		Binding: &operatorBindingCreate{
 			EndpointSelectors: p.endpointSelectors,
 			CSR:               string(csrPEM),
+			IngressEndpoint:   p.bindingOptions.IngressEndpoint,
 		},
 	})
 	if err != nil {
@@ -105,22 +186,256 @@ func (p *certProvisioner) provisionCertificate(ctx context.Context) (tls.Certifi
 	}
 
 	if operator.Binding == nil || operator.Binding.Cert.Cert == "" {
-		return tls.Certificate{}, "", fmt.Errorf("no certificate in response")
+		// Best-effort cleanup so the next attempt starts from a fresh operator
+		if operator.ID != "" {
+			_ = p.apiClient.DeleteOperator(ctx, operator.ID)
+		}
+		return tls.Certificate{}, "", newProvisioningError(operator)
 	}
 
 	certPEM := []byte(operator.Binding.Cert.Cert)
 
+	if err := verifyCertKey(certPEM, privateKey.Public()); err != nil {
+		// Best-effort cleanup; the operator's cert is unusable
+		_ = p.apiClient.DeleteOperator(ctx, operator.ID)
+		return tls.Certificate{}, "", err
+	}
+
 	// Save to store - if this fails, clean up the operator we just created
-	if err := p.store.Save(ctx, privateKeyPEM, certPEM, operator.ID); err != nil {
+	if err := p.storeOp("Save", func() error { return p.store.Save(ctx, privateKeyPEM, certPEM, operator.ID) }); err != nil {
 		// Best-effort cleanup to prevent orphaned operators
 		_ = p.apiClient.DeleteOperator(ctx, operator.ID)
 		return tls.Certificate{}, "", fmt.Errorf("failed to save certificate: %w", err)
 	}
 
-	cert, err := tls.X509KeyPair(certPEM, privateKeyPEM)
+	cert, err := p.keyPair(certPEM, privateKeyPEM)
 	if err != nil {
 		return tls.Certificate{}, "", err
 	}
 
+	p.operatorRaw = operator.Raw
+
 	return cert, operator.ID, nil
 }
+
+// checkStoredOperator applies MissingOperatorPolicy to the operator of a
+// stored certificate, reporting false if the certificate should not be reused.
+// Without a policy set, the operator is not checked.
+func (p *certProvisioner) checkStoredOperator(ctx context.Context, operatorID string) (bool, error) {
+	if p.missingOperator == "" || operatorID == "" {
+		return true, nil
+	}
+
+	_, err := p.apiClient.GetOperator(ctx, operatorID)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, ErrOperatorNotFound) {
+		// An unreachable API is no reason to discard a valid certificate
+		if p.logger.Enabled() {
+			p.logger.Info("Failed to check stored operator; using stored certificate", "operatorID", operatorID, "error", err.Error())
+		}
+		return true, nil
+	}
+
+	switch p.missingOperator {
+	case MissingOperatorError:
+		return false, fmt.Errorf("stored certificate: %w", err)
+	case MissingOperatorReuseLocal:
+		if p.logger.Enabled() {
+			p.logger.Info("Stored operator no longer exists; reusing its certificate", "operatorID", operatorID)
+		}
+		return true, nil
+	default:
+		if p.logger.Enabled() {
+			p.logger.Info("Stored operator no longer exists; provisioning a new one", "operatorID", operatorID)
+		}
+		return false, nil
+	}
+}
+
+// storeOp runs a CertStore operation and logs its duration and outcome at V(1),
+// to make slow or flaky store backends visible.
+func (p *certProvisioner) storeOp(op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+
+	if p.logger.Enabled() {
+		kv := []any{"op", op, "store", fmt.Sprintf("%T", p.store), "duration", time.Since(start)}
+		if err != nil {
+			kv = append(kv, "error", err.Error())
+		}
+		p.logger.V(1).Info("Cert store operation", kv...)
+	}
+
+	return err
+}
+
+// rotateKey generates a new private key and has the API issue a certificate
+// for it under the existing operator, then saves it to the store.
+func (p *certProvisioner) rotateKey(ctx context.Context, operatorID string) (tls.Certificate, error) {
+	if err := p.storeOp("CanWrite", func() error { return p.store.CanWrite(ctx) }); err != nil {
+		return tls.Certificate{}, fmt.Errorf("certificate store not writable: %w", err)
+	}
+
+	privateKey, privateKeyPEM, csrPEM, err := p.keyAndCSR(ctx)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	operator, err := p.apiClient.UpdateOperator(ctx, operatorID, &operatorUpdateRequest{
+		Binding: &operatorBindingUpdate{CSR: string(csrPEM)},
+	})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to update operator: %w", err)
+	}
+
+	if operator.Binding == nil || operator.Binding.Cert.Cert == "" {
+		return tls.Certificate{}, newProvisioningError(operator)
+	}
+
+	certPEM := []byte(operator.Binding.Cert.Cert)
+
+	if err := verifyCertKey(certPEM, privateKey.Public()); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if err := p.storeOp("Save", func() error { return p.store.Save(ctx, privateKeyPEM, certPEM, operatorID) }); err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to save certificate: %w", err)
+	}
+
+	return p.keyPair(certPEM, privateKeyPEM)
+}
+
+// keyAndCSR returns the key and CSR to submit to the API: the external key
+// and the CSR from csrProvider if set, with no key PEM since the key is not
+// stored, or a newly generated key and CSR otherwise.
+func (p *certProvisioner) keyAndCSR(ctx context.Context) (key crypto.Signer, keyPEM, csrPEM []byte, err error) {
+	if p.csrProvider == nil {
+		return p.generateKeyAndCSR()
+	}
+
+	csrPEM, err = p.csrProvider(ctx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get CSR: %w", err)
+	}
+
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, nil, fmt.Errorf("invalid CSR: expected a PEM CERTIFICATE REQUEST block")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	if pub, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(p.externalKey.Public()) {
+		return nil, nil, nil, fmt.Errorf("CSR public key does not match PrivateKey")
+	}
+
+	return p.externalKey, nil, csrPEM, nil
+}
+
+// keyPair builds the client certificate from PEM. A certificate stored without
+// a key is paired with the external key, which is never stored.
+func (p *certProvisioner) keyPair(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	if len(keyPEM) > 0 {
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+	if p.externalKey == nil {
+		return tls.Certificate{}, fmt.Errorf("stored certificate has no private key; set PrivateKey if the key is managed externally")
+	}
+
+	var cert tls.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, fmt.Errorf("no certificate found in PEM data")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(p.externalKey.Public()) {
+		return tls.Certificate{}, fmt.Errorf("certificate does not match PrivateKey")
+	}
+	cert.PrivateKey = p.externalKey
+	cert.Leaf = leaf
+
+	return cert, nil
+}
+
+// generateKeyAndCSR generates an ECDSA P-384 private key and a CSR for it.
+func (p *certProvisioner) generateKeyAndCSR() (privateKey *ecdsa.PrivateKey, keyPEM, csrPEM []byte, err error) {
+	privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keyPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	})
+
+	template := x509.CertificateRequest{
+		Subject:            p.subject,
+		SignatureAlgorithm: p.signatureAlg,
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	csrPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: csrDER,
+	})
+
+	return privateKey, keyPEM, csrPEM, nil
+}
+
+// certValid reports whether the leaf certificate is within its validity period
+// at now. skew tolerates a certificate issued by a clock ahead of ours, and
+// retires one skew before it expires so a clock behind ours never keeps an
+// expired certificate.
+func certValid(cert tls.Certificate, now time.Time, skew time.Duration) bool {
+	if len(cert.Certificate) == 0 {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	return !now.Add(skew).Before(leaf.NotBefore) && now.Add(skew).Before(leaf.NotAfter)
+}
+
+// verifyCertKey checks that the leaf certificate in certPEM was issued for publicKey.
+func verifyCertKey(certPEM []byte, publicKey crypto.PublicKey) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("invalid certificate PEM in response")
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid certificate in response: %w", err)
+	}
+
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(publicKey) {
+		return fmt.Errorf("%w (certificate subject %q): the API likely signed a different CSR", ErrCertKeyMismatch, leaf.Subject.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,562 @@
+package ngrokd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+)
+
+func TestEnsureCertificateUsesValidStoredCert(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	keyPEM, certPEM := encodeTestCert(t, generateTestCert(t))
+	store := NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_stored")
+
+	_, opID, err := newTestProvisioner(api, store).EnsureCertificate(ctx)
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	if opID != "k8sop_stored" {
+		t.Errorf("expected stored operator ID, got %s", opID)
+	}
+	if n := len(api.Created()); n != 0 {
+		t.Errorf("expected no CreateOperator calls with a valid stored cert, got %d", n)
+	}
+}
+
+func TestEnsureCertificateMissingOperatorPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		policy     MissingOperatorPolicy
+		wantOpID   string
+		wantErr    error
+		wantChecks int
+	}{
+		{"", "k8sop_stored", nil, 0},
+		{MissingOperatorReprovision, "k8sop_1", nil, 1},
+		{MissingOperatorError, "", ErrOperatorNotFound, 1},
+		{MissingOperatorReuseLocal, "k8sop_stored", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// The fake API reports k8sop_stored as not found
+			api := newTestAPI(t)
+			keyPEM, certPEM := encodeTestCert(t, generateTestCert(t))
+			p := newTestProvisioner(api, NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_stored"))
+			p.missingOperator = tt.policy
+
+			_, opID, err := p.EnsureCertificate(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if opID != tt.wantOpID {
+				t.Errorf("expected operator %q, got %q", tt.wantOpID, opID)
+			}
+			if n := api.Calls("GET", "/kubernetes_operators/k8sop_stored"); n != tt.wantChecks {
+				t.Errorf("expected %d operator checks, got %d", tt.wantChecks, n)
+			}
+		})
+	}
+}
+
+func TestConfigValidatesMissingOperatorPolicy(t *testing.T) {
+	cfg := Config{MissingOperatorPolicy: "discard"}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+
+	cfg = Config{MissingOperatorPolicy: MissingOperatorReprovision}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected a policy without APIKey to be rejected")
+	}
+
+	if got := (&Config{}).missingOperatorPolicy(); got != MissingOperatorError {
+		t.Errorf("expected error policy without APIKey, got %q", got)
+	}
+	if got := (&Config{APIKey: "key"}).missingOperatorPolicy(); got != MissingOperatorReprovision {
+		t.Errorf("expected reprovision policy with APIKey, got %q", got)
+	}
+}
+
+func TestEnsureCertificateReprovisionsExpiredCert(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	expired := generateTestCertValidity(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	keyPEM, certPEM := encodeTestCert(t, expired)
+	store := NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_stored")
+
+	_, opID, err := newTestProvisioner(api, store).EnsureCertificate(ctx)
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	if opID == "k8sop_stored" {
+		t.Error("expected a new operator for an expired stored cert")
+	}
+	if n := len(api.Created()); n != 1 {
+		t.Errorf("expected 1 CreateOperator call, got %d", n)
+	}
+}
+
+func TestEnsureCertificateToleratesClockSkew(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	// Issued by a server whose clock is two minutes ahead of ours
+	early := generateTestCertValidity(t, time.Now().Add(2*time.Minute), time.Now().Add(time.Hour))
+	keyPEM, certPEM := encodeTestCert(t, early)
+	store := NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_stored")
+
+	_, opID, err := newTestProvisioner(api, store).EnsureCertificate(ctx)
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+	if opID != "k8sop_stored" {
+		t.Errorf("expected stored cert within the default skew to be reused, got operator %s", opID)
+	}
+
+	strict := newTestProvisioner(api, store)
+	strict.clockSkew = time.Minute
+	if _, opID, _ := strict.EnsureCertificate(ctx); opID == "k8sop_stored" {
+		t.Error("expected stored cert beyond ClockSkew to be re-provisioned")
+	}
+}
+
+func TestEnsureCertificateClockSkewNotAfter(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	// Expired a minute ago, well within the default skew
+	expired := generateTestCertValidity(t, time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	keyPEM, certPEM := encodeTestCert(t, expired)
+	store := NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_stored")
+
+	_, opID, err := newTestProvisioner(api, store).EnsureCertificate(ctx)
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+	if opID == "k8sop_stored" {
+		t.Error("expected a cert expired within ClockSkew to be re-provisioned")
+	}
+	if n := len(api.Created()); n != 1 {
+		t.Errorf("expected 1 CreateOperator call, got %d", n)
+	}
+}
+
+func TestProvisionCertKeyMismatch(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	api.signKey = otherKey.Public()
+
+	store := NewMemoryStore()
+	_, _, err = newTestProvisioner(api, store).EnsureCertificate(ctx)
+	if !errors.Is(err, ErrCertKeyMismatch) {
+		t.Fatalf("expected ErrCertKeyMismatch, got %v", err)
+	}
+
+	if exists, _ := store.Exists(ctx); exists {
+		t.Error("expected mismatched cert not to be stored")
+	}
+	if deleted := api.Deleted(); len(deleted) != 1 || deleted[0] != "k8sop_1" {
+		t.Errorf("expected the unusable operator to be deleted, got %v", deleted)
+	}
+}
+
+func TestProvisionNoCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name            string
+		msg             string
+		invalidSelector bool
+	}{
+		{"invalid selector", "Invalid endpoint selector: syntax error at 1:5", true},
+		{"unsupported key", "CSR public key type is not supported", false},
+		{"other selector error", "Too many endpoint selectors for this account", false},
+		{"no explanation", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			api.rejectCreate = map[string]any{"msg": tt.msg}
+			if tt.msg != "" {
+				api.rejectCreate["error_code"] = "ERR_NGROK_400"
+			}
+
+			_, _, err := newTestProvisioner(api, NewMemoryStore()).EnsureCertificate(ctx)
+			var provErr *ProvisioningError
+			if !errors.As(err, &provErr) {
+				t.Fatalf("expected ProvisioningError, got %v", err)
+			}
+			if provErr.Msg != tt.msg || provErr.OperatorID != "k8sop_1" {
+				t.Errorf("unexpected error fields: %+v", provErr)
+			}
+			if tt.msg != "" && !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("expected the API's explanation in %q", err)
+			}
+			if got := errors.Is(err, ErrInvalidEndpointSelector); got != tt.invalidSelector {
+				t.Errorf("errors.Is(err, ErrInvalidEndpointSelector) = %v, want %v", got, tt.invalidSelector)
+			}
+			if deleted := api.Deleted(); len(deleted) != 1 || deleted[0] != "k8sop_1" {
+				t.Errorf("expected the operator without a certificate to be deleted, got %v", deleted)
+			}
+		})
+	}
+}
+
+func TestProvisionBindingOptions(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	if _, _, err := newTestProvisioner(api, NewMemoryStore()).EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	p := newTestProvisioner(api, NewMemoryStore())
+	p.bindingOptions = BindingOptions{Region: "eu", IngressEndpoint: "eu.ingress.example:443"}
+	if _, _, err := p.EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	created := api.Created()
+	if len(created) != 2 {
+		t.Fatalf("expected 2 operator creates, got %d", len(created))
+	}
+
+	unset, _ := json.Marshal(created[0])
+	if created[0].Region != "global" || strings.Contains(string(unset), "ingress_endpoint") {
+		t.Errorf("expected unset options to keep the default request, got %s", unset)
+	}
+
+	if created[1].Region != "eu" || created[1].Binding.IngressEndpoint != "eu.ingress.example:443" {
+		t.Errorf("expected binding options in create request, got %+v", created[1])
+	}
+}
+
+func TestProvisionCSRSubject(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	p := newTestProvisioner(api, NewMemoryStore())
+	p.subject = pkix.Name{
+		CommonName:         "payments-client",
+		Organization:       []string{"Example Corp"},
+		OrganizationalUnit: []string{"Payments"},
+	}
+
+	if _, _, err := p.EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	csr := parseCreatedCSR(t, api)
+	if csr.Subject.CommonName != "payments-client" {
+		t.Errorf("expected CN payments-client, got %q", csr.Subject.CommonName)
+	}
+	if len(csr.Subject.OrganizationalUnit) != 1 || csr.Subject.OrganizationalUnit[0] != "Payments" {
+		t.Errorf("expected OU Payments, got %v", csr.Subject.OrganizationalUnit)
+	}
+}
+
+func TestProvisionDefaultCSRSubject(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	if _, _, err := newTestProvisioner(api, NewMemoryStore()).EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	csr := parseCreatedCSR(t, api)
+	if len(csr.Subject.Organization) != 1 || csr.Subject.Organization[0] != "ngrokd-sdk" {
+		t.Errorf("expected default O=ngrokd-sdk, got %v", csr.Subject.Organization)
+	}
+}
+
+func TestConfigRejectsEmptyCSRSubject(t *testing.T) {
+	cfg := Config{CSRSubject: &pkix.Name{}}
+	if err := cfg.setDefaults(); err == nil {
+		t.Fatal("expected error for empty CSRSubject")
+	}
+}
+
+func TestProvisionCSRSignatureAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	cfg := Config{CertStore: NewMemoryStore(), CSRSignatureAlgorithm: x509.ECDSAWithSHA256}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	client := newAPIClient("test-api-key")
+	client.baseURL = api.URL
+
+	if _, _, err := newCertProvisioner(cfg, client).EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	if alg := parseCreatedCSR(t, api).SignatureAlgorithm; alg != x509.ECDSAWithSHA256 {
+		t.Errorf("expected CSR signed with ECDSA-SHA256, got %s", alg)
+	}
+}
+
+func TestConfigRejectsIncompatibleCSRSignatureAlgorithm(t *testing.T) {
+	cfg := Config{CSRSignatureAlgorithm: x509.SHA256WithRSA}
+	if err := cfg.setDefaults(); err == nil {
+		t.Fatal("expected error for an RSA signature algorithm with an ECDSA key")
+	}
+
+	cfg = Config{}
+	if err := cfg.setDefaults(); err != nil || cfg.CSRSignatureAlgorithm != x509.ECDSAWithSHA384 {
+		t.Errorf("expected default ECDSA-SHA384, got %s (%v)", cfg.CSRSignatureAlgorithm, err)
+	}
+}
+
+func TestProvisionExternalCSR(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "central-ca"},
+	}, key)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	store := NewMemoryStore()
+	cfg := Config{CertStore: store, CSRPEM: csrPEM, PrivateKey: key}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	client := newAPIClient("test-api-key")
+	client.baseURL = api.URL
+
+	cert, opID, err := newCertProvisioner(cfg, client).EnsureCertificate(ctx)
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+	if cert.PrivateKey != key {
+		t.Error("expected the certificate to use the external key")
+	}
+	if cn := parseCreatedCSR(t, api).Subject.CommonName; cn != "central-ca" {
+		t.Errorf("expected the external CSR to be submitted, got CN %q", cn)
+	}
+
+	storedKey, storedCert, storedOpID, _ := store.Load(ctx)
+	if len(storedKey) != 0 {
+		t.Error("expected no private key in the store")
+	}
+	if storedOpID != opID {
+		t.Errorf("expected stored operator %s, got %s", opID, storedOpID)
+	}
+
+	// The stored certificate without a key is reused with the external key
+	if _, _, err := newCertProvisioner(cfg, client).EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate from store failed: %v", err)
+	}
+	if n := len(api.Created()); n != 1 {
+		t.Errorf("expected 1 CreateOperator call, got %d", n)
+	}
+
+	// Without the external key, a certificate stored without one is unusable
+	cfg.CSRPEM, cfg.PrivateKey = nil, nil
+	if _, err := newCertProvisioner(cfg, client).keyPair(storedCert, nil); err == nil {
+		t.Error("expected error for a stored certificate without a key")
+	}
+}
+
+func TestProvisionExternalCSRKeyMismatch(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	csrKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, csrKey)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+
+	cfg := Config{
+		CertStore: NewMemoryStore(),
+		CSRProvider: func(context.Context) ([]byte, error) {
+			return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), nil
+		},
+		PrivateKey: otherKey,
+	}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+	client := newAPIClient("test-api-key")
+	client.baseURL = api.URL
+
+	if _, _, err := newCertProvisioner(cfg, client).EnsureCertificate(ctx); err == nil {
+		t.Fatal("expected error for a CSR not matching PrivateKey")
+	}
+	if n := len(api.Created()); n != 0 {
+		t.Errorf("expected no operator to be created, got %d", n)
+	}
+}
+
+func TestConfigRejectsExternalCSRWithoutKey(t *testing.T) {
+	cfg := Config{CSRPEM: []byte("csr")}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected error for CSRPEM without PrivateKey")
+	}
+}
+
+// parseCreatedCSR returns the CSR from the first operator created on api.
+func parseCreatedCSR(t *testing.T, api *testAPI) *x509.CertificateRequest {
+	t.Helper()
+
+	created := api.Created()
+	if len(created) == 0 {
+		t.Fatal("expected an operator to be created")
+	}
+
+	block, _ := pem.Decode([]byte(created[0].Binding.CSR))
+	if block == nil {
+		t.Fatal("invalid CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+	return csr
+}
+
+// encodeTestCert returns PEM-encoded key and certificate for cert.
+func encodeTestCert(t *testing.T, cert tls.Certificate) (keyPEM, certPEM []byte) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	return keyPEM, certPEM
+}
+
+func TestEnsureCertificateKeyedStoreSelectsOperator(t *testing.T) {
+	ctx := context.Background()
+	store := NewKeyedFileStore(t.TempDir())
+
+	certs := map[string]tls.Certificate{}
+	for _, opID := range []string{"op_a", "op_b"} {
+		certs[opID] = generateTestCert(t)
+		keyPEM, certPEM := encodeTestCert(t, certs[opID])
+		if err := store.Save(ctx, keyPEM, certPEM, opID); err != nil {
+			t.Fatalf("Save %s failed: %v", opID, err)
+		}
+	}
+
+	for _, opID := range []string{"op_a", "op_b"} {
+		cfg := Config{CertStore: store, OperatorID: opID}
+		cfg.setDefaults()
+
+		// No API client: selecting a stored identity must not provision
+		cert, gotID, err := newCertProvisioner(cfg, nil).EnsureCertificate(ctx)
+		if err != nil {
+			t.Fatalf("EnsureCertificate %s failed: %v", opID, err)
+		}
+		if gotID != opID || !bytes.Equal(cert.Certificate[0], certs[opID].Certificate[0]) {
+			t.Errorf("expected identity of %s, got %s", opID, gotID)
+		}
+	}
+
+	cfg := Config{CertStore: store, OperatorID: "op_missing"}
+	cfg.setDefaults()
+	if _, _, err := newCertProvisioner(cfg, nil).EnsureCertificate(ctx); err == nil {
+		t.Error("expected error for operator missing from the keyed store")
+	}
+}
+
+// slowStore delays Load on the wrapped CertStore.
+type slowStore struct {
+	CertStore
+	delay time.Duration
+}
+
+func (s *slowStore) Load(ctx context.Context) (key, cert []byte, operatorID string, err error) {
+	time.Sleep(s.delay)
+	return s.CertStore.Load(ctx)
+}
+
+func TestEnsureCertificateLogsStoreOperations(t *testing.T) {
+	ctx := context.Background()
+	api := newTestAPI(t)
+
+	keyPEM, certPEM := encodeTestCert(t, generateTestCert(t))
+	store := &slowStore{CertStore: NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_stored"), delay: 20 * time.Millisecond}
+
+	var mu sync.Mutex
+	var entries []string
+	logger := funcr.NewJSON(func(obj string) {
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, obj)
+	}, funcr.Options{Verbosity: 1})
+
+	p := newTestProvisioner(api, store)
+	p.logger = logger
+	if _, _, err := p.EnsureCertificate(ctx); err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var load *struct {
+		Op       string `json:"op"`
+		Duration string `json:"duration"`
+	}
+	for _, e := range entries {
+		if err := json.Unmarshal([]byte(e), &load); err != nil {
+			t.Fatalf("failed to parse log entry %q: %v", e, err)
+		}
+		if load.Op == "Load" {
+			break
+		}
+		load = nil
+	}
+	if load == nil {
+		t.Fatalf("expected a log entry for Load, got %v", entries)
+	}
+
+	d, err := time.ParseDuration(load.Duration)
+	if err != nil {
+		t.Fatalf("invalid duration %q: %v", load.Duration, err)
+	}
+	if d < store.delay {
+		t.Errorf("expected logged Load duration of at least %s, got %s", store.delay, d)
+	}
+}
@@ -2,22 +2,45 @@ package ngrokd
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 )
 
 const defaultIngressEndpoint = "kubernetes-binding-ingress.ngrok.io:443"
 
+// defaultDiscoveryTimeout leaves room for both API requests made by a discovery.
+const defaultDiscoveryTimeout = time.Minute
+
+// defaultBulkDialConcurrency bounds bulk dialing without flooding the ingress.
+const defaultBulkDialConcurrency = 8
+
+// defaultClockSkew tolerates typical NTP drift between the client and the API.
+const defaultClockSkew = 5 * time.Minute
+
 // Config holds the configuration for a Dialer with API-based discovery.
 type Config struct {
 	// APIKey is the ngrok API key for provisioning certificates and discovering endpoints.
-	// Required.
+	// Required unless both Cert and EndpointSource are set.
 	APIKey string
 
+	// APIKeyProvider, if set, supplies the API key for each API request in
+	// place of APIKey, so short-lived keys can rotate without recreating the
+	// dialer. Keys are reused for a minute, or until the API rejects them.
+	APIKeyProvider func(ctx context.Context) (string, error)
+
 	// OperatorID is an existing operator ID to use for discovery.
+	// If CertStore is a KeyedCertStore, the identity for this operator is loaded from it.
 	// If empty, will be loaded from CertStore or provisioned.
 	OperatorID string
 
@@ -29,7 +52,13 @@ type Config struct {
 	// Default: FileStore at ~/.ngrokd-go/certs
 	CertStore CertStore
 
-	// IngressEndpoint is the ngrok ingress endpoint.
+	// Profile selects a named identity in the default FileStore,
+	// stored under ~/.ngrokd-go/certs/<profile>. Only used if CertStore is nil.
+	// Default: "" (~/.ngrokd-go/certs)
+	Profile string
+
+	// IngressEndpoint is the ngrok ingress endpoint as host:port.
+	// If the port is omitted, 443 is used.
 	// Default: kubernetes-binding-ingress.ngrok.io:443
 	IngressEndpoint string
 
@@ -37,6 +66,34 @@ type Config struct {
 	// If nil, system roots are used (with fallback to InsecureSkipVerify).
 	RootCAs *x509.CertPool
 
+	// RootCAsFile and RootCAsPEM are PEM-encoded CA certificates added to RootCAs,
+	// for deployments configured from files rather than code.
+	RootCAsFile string
+	RootCAsPEM  []byte
+
+	// OnProtoMismatch is called when a dialed URL's scheme, e.g. tcp, differs
+	// from the proto the endpoint was bound as, e.g. http. This usually means a
+	// misconfigured address. The connection is returned regardless.
+	OnProtoMismatch func(address, requested, bound string)
+
+	// DialAuthorizer, if set, is called before each dial opens an ingress
+	// connection, with the dial's context and the resolved endpoint. A non-nil
+	// return aborts the dial with an AuthorizationDeniedError wrapping it.
+	// Endpoints not yet discovered are passed with only the hostname and port
+	// set in URL.
+	DialAuthorizer func(ctx context.Context, ep Endpoint) error
+
+	// BindingRequestHook, if set, is called with each binding request before
+	// it is sent to the ingress, and the endpoint it was resolved to as passed
+	// to DialAuthorizer. Changes it makes to base are sent.
+	BindingRequestHook func(base *ConnRequest, ep Endpoint)
+
+	// OnIngressCertChange is called when the ingress certificate fails
+	// verification against RootCAs, e.g. after the ingress rotated to a CA
+	// that is not yet trusted, so the application can refresh its trust store.
+	// The dial still fails with a *TLSHandshakeError.
+	OnIngressCertChange func(ingressEndpoint string, err error)
+
 	// IngressDialer dials the ngrok ingress endpoint.
 	// If nil, uses net.Dialer with 30s timeout.
 	IngressDialer ContextDialer
@@ -44,9 +101,279 @@ type Config struct {
 	// Logger for structured logging.
 	Logger logr.Logger
 
+	// HostnameRewrite maps the hostname parsed from a dialed address to the
+	// ngrok endpoint hostname, e.g. "app.internal" to "app.namespace".
+	// If nil, hostnames are used as-is.
+	HostnameRewrite func(hostname string) string
+
+	// EndpointAliases maps exact shortnames to endpoint hostnames,
+	// e.g. "payments" to "payments.prod". An alias match takes precedence
+	// over HostnameRewrite.
+	EndpointAliases map[string]string
+
+	// AllowHosts are glob patterns (e.g. "*.prod") of hostnames this dialer may reach.
+	// Empty means all hostnames are allowed.
+	AllowHosts []string
+
+	// DenyHosts are glob patterns of hostnames this dialer must not reach.
+	// Deny takes precedence over AllowHosts.
+	DenyHosts []string
+
+	// DialTimeouts overrides the timeout for the whole dial (ingress connect,
+	// TLS handshake and binding upgrade) per endpoint hostname.
+	// Hostnames not listed are bounded only by the context and IngressDialer.
+	DialTimeouts map[string]time.Duration
+
+	// ConnReadTimeout and ConnWriteTimeout are idle timeouts applied to bound
+	// connections: each Read or Write fails if it makes no progress for this long.
+	// They are defaults only; calling SetDeadline, SetReadDeadline or
+	// SetWriteDeadline on the connection replaces them for that direction.
+	// Default: 0 (no timeout)
+	ConnReadTimeout  time.Duration
+	ConnWriteTimeout time.Duration
+
+	// KeepAlive enables TCP keep-alive probes with this period on the socket
+	// underlying each bound connection, so half-open connections are detected
+	// on long-lived streams. Applies to any IngressDialer returning a *net.TCPConn
+	// or other connection supporting SetKeepAlivePeriod.
+	// Default: 0 (left to the IngressDialer)
+	KeepAlive time.Duration
+
+	// RenewBefore is how long before the client certificate expires RenewAfter
+	// reports that it is due for renewal.
+	// Default: 0 (a third of the certificate's validity period)
+	RenewBefore time.Duration
+
+	// DetectImmediateClose makes the first Read on a bound connection return a
+	// *BackendRefusedError instead of io.EOF when the connection is closed
+	// without any data shortly after the binding upgrade, which usually means
+	// the backend refused it.
+	// Default: false
+	DetectImmediateClose bool
+
+	// CaptureFailedHandshakes attaches the hex-encoded request and response
+	// frames to the BindingProtocolError returned when a binding response
+	// cannot be decoded. Nothing is captured for successful upgrades.
+	// Default: false
+	CaptureFailedHandshakes bool
+
+	// CertPreference decides where the certificate comes from when Cert is set
+	// alongside an APIKey or a CertStore holding a certificate.
+	// Default: CertPreferSupplied
+	CertPreference CertPreference
+
+	// MissingOperatorPolicy decides what happens when the API reports that
+	// the operator of the certificate in use no longer exists. Setting it also
+	// checks the operator of a certificate loaded from CertStore before it is
+	// used; otherwise only CertRevalidateInterval checks. Requires APIKey.
+	// Default: MissingOperatorReprovision with APIKey, else MissingOperatorError
+	MissingOperatorPolicy MissingOperatorPolicy
+
+	// TrustOperatorChain verifies the ingress against the CA certificates that
+	// follow the leaf in the operator certificate, in addition to RootCAs.
+	// When the API returns the issuing chain, this enables ingress
+	// verification without configuring RootCAs; without a chain it has no effect.
+	// Default: false
+	TrustOperatorChain bool
+
+	// CertRevalidateInterval periodically confirms with the API that the
+	// operator still exists, even while its certificate is valid. The check
+	// runs on the first use of the dialer after each interval; if the operator
+	// was deleted, a new operator and certificate are provisioned. Requires APIKey.
+	// Default: 0 (never revalidated)
+	CertRevalidateInterval time.Duration
+
+	// LazyProvision defers loading or provisioning the certificate from
+	// DiscoveryDialer to the first dial, discovery, ExportIdentity or RotateKey,
+	// so construction makes no store access or API call. Provisioning errors
+	// are then returned by that first use, which is retried on the next.
+	// Default: false
+	LazyProvision bool
+
+	// DiscoverOnStart runs an endpoint discovery in DiscoveryDialer, so the
+	// endpoint cache is populated before the first dial. It cannot be combined
+	// with LazyProvision.
+	// Default: false
+	DiscoverOnStart bool
+
+	// IgnoreInitialDiscoveryError logs a failed DiscoverOnStart discovery
+	// instead of returning it from DiscoveryDialer. The endpoint cache is then
+	// populated by the next successful discovery. Provisioning cut short by
+	// StartupTimeout is likewise logged and retried on next use.
+	// Default: false
+	IgnoreInitialDiscoveryError bool
+
+	// StartupTimeout bounds the provisioning and DiscoverOnStart discovery
+	// done by DiscoveryDialer, together. Exceeding it fails construction
+	// unless IgnoreInitialDiscoveryError is set. It does not apply to later calls.
+	// Default: 0 (no limit beyond ctx and DiscoveryTimeout)
+	StartupTimeout time.Duration
+
 	// EndpointSelectors are CEL expressions that filter which endpoints this operator can access.
 	// Default: ["true"] (matches all endpoints)
 	EndpointSelectors []string
+
+	// OperatorMetadata is attached as JSON metadata to operators provisioned by this dialer,
+	// e.g. to tag operators for later lookup or cleanup. Values must be JSON-marshalable.
+	// Default: {"type": "sdk"}
+	OperatorMetadata map[string]any
+
+	// BindingOptions are optional settings sent to the API when provisioning an operator.
+	BindingOptions BindingOptions
+
+	// CSRSubject is the subject of the CSR submitted when provisioning,
+	// for accounts or policy engines that require specific CN/O/OU values.
+	// Default: O=ngrokd-sdk
+	CSRSubject *pkix.Name
+
+	// CSRSignatureAlgorithm signs the CSR submitted when provisioning. It must
+	// be an ECDSA algorithm, matching the P-384 key the SDK generates.
+	// Default: x509.ECDSAWithSHA384
+	CSRSignatureAlgorithm x509.SignatureAlgorithm
+
+	// CSRPEM and CSRProvider supply a PEM-encoded CSR built outside the
+	// process, e.g. by a central CA service, to submit when provisioning
+	// instead of generating a key and CSR. Set at most one; PrivateKey is
+	// required with either.
+	CSRPEM      []byte
+	CSRProvider func(ctx context.Context) ([]byte, error)
+
+	// PrivateKey is the externally managed key matching CSRPEM or CSRProvider,
+	// such as an HSM-backed crypto.Signer. It is used for mTLS but never
+	// written to the CertStore, which holds only the certificate.
+	PrivateKey crypto.Signer
+
+	// EndpointSource supplies the endpoints returned by Endpoints, e.g. from a
+	// service registry or static configuration, in place of the ngrok API.
+	// Its URLs are normalized like StaticEndpoints, and a listing with an
+	// endpoint whose URL lacks a scheme or host fails the discovery.
+	// Default: bound endpoints of the operator, listed via the ngrok API
+	EndpointSource EndpointSource
+
+	// StaticEndpoints are known endpoints loaded at construction, so dials that
+	// need a discovered port, such as tcp:// addresses without one, work before
+	// any discovery. They are overlaid on every discovery result, replacing a
+	// discovered endpoint with the same URL.
+	// Default: nil
+	StaticEndpoints []Endpoint
+
+	// ClockSkew is the tolerance applied to certificate validity when deciding
+	// whether a stored certificate can be reused: a certificate up to ClockSkew
+	// before its NotBefore is accepted, so a slightly skewed local clock does
+	// not reject a fresh certificate, and one within ClockSkew of its NotAfter
+	// is replaced, so a skewed clock never keeps an expired one.
+	// Default: 5m
+	ClockSkew time.Duration
+
+	// EndpointMergePolicy combines two endpoints with the same URL found during
+	// API discovery, e.g. to prefer the one with metadata. It is not applied
+	// to a custom EndpointSource.
+	// Default: nil (the first endpoint is kept)
+	EndpointMergePolicy func(existing, incoming Endpoint) Endpoint
+
+	// OnCacheEmpty is called when a discovery leaves no endpoints cached
+	// although the previous one found some, e.g. because every endpoint was
+	// deleted. It is not called while the cache has never held endpoints.
+	// It runs after the discovery completes, so it may call the dialer.
+	// Default: nil
+	OnCacheEmpty func()
+
+	// Quarantine stops dialing endpoints whose binding upgrades keep failing.
+	// Default: disabled
+	Quarantine QuarantineConfig
+
+	// DedupByID deduplicates endpoints found during API discovery by ID rather
+	// than URL, keeping endpoints that intentionally share a URL, e.g. for
+	// blue/green deployments. EndpointMergePolicy then combines endpoints with
+	// the same ID. It is not applied to a custom EndpointSource.
+	// Default: false (deduplicate by URL)
+	DedupByID bool
+
+	// VerifyOnDiscover probes each discovered endpoint with a binding upgrade
+	// and sets Endpoint.Reachable. Unreachable endpoints are still returned.
+	// Default: false
+	VerifyOnDiscover bool
+
+	// BulkDialConcurrency is the maximum number of connections opened at once
+	// by bulk operations such as VerifyOnDiscover. Ordinary dials are not limited.
+	// Default: 8
+	BulkDialConcurrency int
+
+	// DiscoveryTimeout bounds each endpoint discovery whose context has no
+	// deadline, so a stalled API or EndpointSource cannot block forever.
+	// A deadline set on the caller's context is always used as-is.
+	// Default: 1m
+	DiscoveryTimeout time.Duration
+
+	// MinDiscoverInterval is the minimum time between endpoint discoveries.
+	// Endpoints called within this window of the last successful discovery
+	// returns the previous result without an API call. ForceRefresh bypasses it.
+	// Default: 0 (every call hits the API)
+	MinDiscoverInterval time.Duration
+}
+
+// CertPreference selects the source of a discovery dialer's certificate.
+type CertPreference string
+
+const (
+	// CertPreferSupplied uses Config.Cert if set, and otherwise a valid
+	// certificate from the CertStore, provisioning one if there is none.
+	CertPreferSupplied CertPreference = "supplied"
+
+	// CertPreferStore uses a valid certificate from the CertStore, falling
+	// back to Config.Cert, and provisions only if neither is available.
+	CertPreferStore CertPreference = "store"
+
+	// CertPreferProvision always provisions a new operator and certificate,
+	// ignoring Config.Cert and the CertStore's contents. Requires APIKey.
+	CertPreferProvision CertPreference = "provision"
+)
+
+// MissingOperatorPolicy selects how a discovery dialer handles an operator
+// that was deleted server-side while its certificate is still held.
+type MissingOperatorPolicy string
+
+const (
+	// MissingOperatorReprovision provisions a new operator and certificate.
+	MissingOperatorReprovision MissingOperatorPolicy = "reprovision"
+
+	// MissingOperatorError returns ErrOperatorNotFound until the dialer is
+	// given a usable identity.
+	MissingOperatorError MissingOperatorPolicy = "error"
+
+	// MissingOperatorReuseLocal keeps using the held certificate, for
+	// deployments where the API's view of operators lags the ingress.
+	MissingOperatorReuseLocal MissingOperatorPolicy = "reuse-local"
+)
+
+// hasAPIKey reports whether APIKey or APIKeyProvider is set.
+func (c *Config) hasAPIKey() bool {
+	return c.APIKey != "" || c.APIKeyProvider != nil
+}
+
+// missingOperatorPolicy returns MissingOperatorPolicy, or its default.
+func (c *Config) missingOperatorPolicy() MissingOperatorPolicy {
+	switch {
+	case c.MissingOperatorPolicy != "":
+		return c.MissingOperatorPolicy
+	case c.hasAPIKey():
+		return MissingOperatorReprovision
+	default:
+		return MissingOperatorError
+	}
+}
+
+// BindingOptions are optional settings for operators provisioned by a
+// DiscoveryDialer. Zero values are left out of the create request.
+type BindingOptions struct {
+	// Region is the region of the operator.
+	// Default: "global"
+	Region string
+
+	// IngressEndpoint requests the ingress endpoint the binding is served from.
+	// It does not change where the dialer connects; see Config.IngressEndpoint.
+	// Default: "" (chosen by the API)
+	IngressEndpoint string
 }
 
 // DirectConfig holds the configuration for a Dialer without API access.
@@ -57,10 +384,21 @@ type DirectConfig struct {
 
 	// CertStore is the storage backend to load certificates from.
 	// Only used if Cert is not provided.
-	// Default: FileStore at ~/.ngrokd-go/certs
+	// Default: FileStore at CertDir
 	CertStore CertStore
 
-	// IngressEndpoint is the ngrok ingress endpoint.
+	// CertDir is the directory of the default FileStore, e.g. one provisioned
+	// by a DiscoveryDialer elsewhere. Only used if CertStore is nil.
+	// Default: ~/.ngrokd-go/certs
+	CertDir string
+
+	// Profile selects a named identity in the default FileStore,
+	// loaded from <CertDir>/<profile>. Only used if CertStore is nil.
+	// Default: "" (CertDir)
+	Profile string
+
+	// IngressEndpoint is the ngrok ingress endpoint as host:port.
+	// If the port is omitted, 443 is used.
 	// Default: kubernetes-binding-ingress.ngrok.io:443
 	IngressEndpoint string
 
@@ -68,12 +406,84 @@ type DirectConfig struct {
 	// If nil, system roots are used (with fallback to InsecureSkipVerify).
 	RootCAs *x509.CertPool
 
+	// RootCAsFile and RootCAsPEM are PEM-encoded CA certificates added to RootCAs,
+	// for deployments configured from files rather than code.
+	RootCAsFile string
+	RootCAsPEM  []byte
+
+	// OnProtoMismatch is called when a dialed URL's scheme, e.g. tcp, differs
+	// from the proto the endpoint was bound as, e.g. http. This usually means a
+	// misconfigured address. The connection is returned regardless.
+	OnProtoMismatch func(address, requested, bound string)
+
+	// OnIngressCertChange is called when the ingress certificate fails
+	// verification against RootCAs, e.g. after the ingress rotated to a CA
+	// that is not yet trusted, so the application can refresh its trust store.
+	// The dial still fails with a *TLSHandshakeError.
+	OnIngressCertChange func(ingressEndpoint string, err error)
+
 	// IngressDialer dials the ngrok ingress endpoint.
 	// If nil, uses net.Dialer with 30s timeout.
 	IngressDialer ContextDialer
 
 	// Logger for structured logging.
 	Logger logr.Logger
+
+	// HostnameRewrite maps the hostname parsed from a dialed address to the
+	// ngrok endpoint hostname, e.g. "app.internal" to "app.namespace".
+	// If nil, hostnames are used as-is.
+	HostnameRewrite func(hostname string) string
+
+	// EndpointAliases maps exact shortnames to endpoint hostnames,
+	// e.g. "payments" to "payments.prod". An alias match takes precedence
+	// over HostnameRewrite.
+	EndpointAliases map[string]string
+
+	// AllowHosts are glob patterns (e.g. "*.prod") of hostnames this dialer may reach.
+	// Empty means all hostnames are allowed.
+	AllowHosts []string
+
+	// DenyHosts are glob patterns of hostnames this dialer must not reach.
+	// Deny takes precedence over AllowHosts.
+	DenyHosts []string
+
+	// DialTimeouts overrides the timeout for the whole dial (ingress connect,
+	// TLS handshake and binding upgrade) per endpoint hostname.
+	// Hostnames not listed are bounded only by the context and IngressDialer.
+	DialTimeouts map[string]time.Duration
+
+	// ConnReadTimeout and ConnWriteTimeout are idle timeouts applied to bound
+	// connections: each Read or Write fails if it makes no progress for this long.
+	// They are defaults only; calling SetDeadline, SetReadDeadline or
+	// SetWriteDeadline on the connection replaces them for that direction.
+	// Default: 0 (no timeout)
+	ConnReadTimeout  time.Duration
+	ConnWriteTimeout time.Duration
+
+	// KeepAlive enables TCP keep-alive probes with this period on the socket
+	// underlying each bound connection, so half-open connections are detected
+	// on long-lived streams. Applies to any IngressDialer returning a *net.TCPConn
+	// or other connection supporting SetKeepAlivePeriod.
+	// Default: 0 (left to the IngressDialer)
+	KeepAlive time.Duration
+
+	// RenewBefore is how long before the client certificate expires RenewAfter
+	// reports that it is due for renewal.
+	// Default: 0 (a third of the certificate's validity period)
+	RenewBefore time.Duration
+
+	// DetectImmediateClose makes the first Read on a bound connection return a
+	// *BackendRefusedError instead of io.EOF when the connection is closed
+	// without any data shortly after the binding upgrade, which usually means
+	// the backend refused it.
+	// Default: false
+	DetectImmediateClose bool
+
+	// CaptureFailedHandshakes attaches the hex-encoded request and response
+	// frames to the BindingProtocolError returned when a binding response
+	// cannot be decoded. Nothing is captured for successful upgrades.
+	// Default: false
+	CaptureFailedHandshakes bool
 }
 
 // ContextDialer matches the net.Dialer.DialContext signature.
@@ -81,31 +491,279 @@ type ContextDialer interface {
 	DialContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
-func (c *Config) setDefaults() {
+func (c *Config) setDefaults() error {
+	if err := validateProfile(c.Profile); err != nil {
+		return err
+	}
 	if c.CertStore == nil {
-		c.CertStore = NewFileStore("")
+		c.CertStore = NewFileStoreProfile("", c.Profile)
 	}
 	if c.IngressEndpoint == "" {
 		c.IngressEndpoint = defaultIngressEndpoint
 	}
+	ingressEndpoint, err := normalizeIngressEndpoint(c.IngressEndpoint)
+	if err != nil {
+		return err
+	}
+	c.IngressEndpoint = ingressEndpoint
+	if c.RootCAs, err = loadRootCAs(c.RootCAs, c.RootCAsFile, c.RootCAsPEM); err != nil {
+		return err
+	}
+	if err := validateHostPatterns(c.AllowHosts, c.DenyHosts); err != nil {
+		return err
+	}
 	if c.IngressDialer == nil {
 		c.IngressDialer = defaultDialer()
 	}
 	if len(c.EndpointSelectors) == 0 {
 		c.EndpointSelectors = []string{"true"}
 	}
+	if c.StaticEndpoints, err = normalizeEndpoints("static", c.StaticEndpoints); err != nil {
+		return err
+	}
+	if c.ClockSkew < 0 {
+		return fmt.Errorf("ClockSkew must not be negative")
+	}
+	if c.ClockSkew == 0 {
+		c.ClockSkew = defaultClockSkew
+	}
+	if c.BulkDialConcurrency < 0 {
+		return fmt.Errorf("BulkDialConcurrency must not be negative")
+	}
+	if c.BulkDialConcurrency == 0 {
+		c.BulkDialConcurrency = defaultBulkDialConcurrency
+	}
+	if c.DiscoveryTimeout == 0 {
+		c.DiscoveryTimeout = defaultDiscoveryTimeout
+	}
+	if c.OperatorMetadata == nil {
+		c.OperatorMetadata = map[string]any{"type": "sdk"}
+	}
+	if c.CSRSubject != nil && len(c.CSRSubject.ToRDNSequence()) == 0 {
+		return fmt.Errorf("invalid CSRSubject: at least one attribute is required")
+	}
+	if c.CertRevalidateInterval < 0 {
+		return fmt.Errorf("CertRevalidateInterval must not be negative")
+	}
+	if c.CertRevalidateInterval > 0 && !c.hasAPIKey() {
+		return fmt.Errorf("CertRevalidateInterval requires APIKey")
+	}
+	if c.Quarantine.Threshold < 0 {
+		return fmt.Errorf("Quarantine.Threshold must not be negative")
+	}
+	if c.Quarantine.Cooldown < 0 {
+		return fmt.Errorf("Quarantine.Cooldown must not be negative")
+	}
+	if c.Quarantine.Cooldown == 0 {
+		c.Quarantine.Cooldown = defaultQuarantineCooldown
+	}
+	if c.StartupTimeout < 0 {
+		return fmt.Errorf("StartupTimeout must not be negative")
+	}
+	if c.DiscoverOnStart && c.LazyProvision {
+		return fmt.Errorf("DiscoverOnStart and LazyProvision are mutually exclusive")
+	}
+	switch c.CertPreference {
+	case "":
+		c.CertPreference = CertPreferSupplied
+	case CertPreferSupplied, CertPreferStore:
+	case CertPreferProvision:
+		if !c.hasAPIKey() {
+			return fmt.Errorf("CertPreference %q requires APIKey", c.CertPreference)
+		}
+	default:
+		return fmt.Errorf("invalid CertPreference %q", c.CertPreference)
+	}
+	switch c.MissingOperatorPolicy {
+	case "":
+	case MissingOperatorReprovision, MissingOperatorError, MissingOperatorReuseLocal:
+		if !c.hasAPIKey() {
+			return fmt.Errorf("MissingOperatorPolicy %q requires APIKey", c.MissingOperatorPolicy)
+		}
+	default:
+		return fmt.Errorf("invalid MissingOperatorPolicy %q", c.MissingOperatorPolicy)
+	}
+	if c.CSRPEM != nil && c.CSRProvider != nil {
+		return fmt.Errorf("CSRPEM and CSRProvider are mutually exclusive")
+	}
+	if (c.CSRPEM != nil || c.CSRProvider != nil) != (c.PrivateKey != nil) {
+		return fmt.Errorf("PrivateKey must be set together with CSRPEM or CSRProvider")
+	}
+	switch c.CSRSignatureAlgorithm {
+	case x509.UnknownSignatureAlgorithm:
+		c.CSRSignatureAlgorithm = x509.ECDSAWithSHA384
+	case x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+	default:
+		return fmt.Errorf("invalid CSRSignatureAlgorithm %s: not compatible with the ECDSA P-384 key", c.CSRSignatureAlgorithm)
+	}
+	return nil
 }
 
-func (c *DirectConfig) setDefaults() {
+func (c *DirectConfig) setDefaults() error {
+	if err := validateProfile(c.Profile); err != nil {
+		return err
+	}
 	if c.CertStore == nil {
-		c.CertStore = NewFileStore("")
+		c.CertStore = NewFileStoreProfile(c.CertDir, c.Profile)
 	}
 	if c.IngressEndpoint == "" {
 		c.IngressEndpoint = defaultIngressEndpoint
 	}
+	ingressEndpoint, err := normalizeIngressEndpoint(c.IngressEndpoint)
+	if err != nil {
+		return err
+	}
+	c.IngressEndpoint = ingressEndpoint
+	if c.RootCAs, err = loadRootCAs(c.RootCAs, c.RootCAsFile, c.RootCAsPEM); err != nil {
+		return err
+	}
+	if err := validateHostPatterns(c.AllowHosts, c.DenyHosts); err != nil {
+		return err
+	}
 	if c.IngressDialer == nil {
 		c.IngressDialer = defaultDialer()
 	}
+	return nil
+}
+
+// clone returns a copy of c that shares no slices or maps with it.
+func (c Config) clone() Config {
+	c.Cert.Certificate = append([][]byte(nil), c.Cert.Certificate...)
+	c.RootCAsPEM = append([]byte(nil), c.RootCAsPEM...)
+	c.CSRPEM = append([]byte(nil), c.CSRPEM...)
+	c.AllowHosts = append([]string(nil), c.AllowHosts...)
+	c.DenyHosts = append([]string(nil), c.DenyHosts...)
+	c.DialTimeouts = copyDurations(c.DialTimeouts)
+	c.EndpointAliases = copyStrings(c.EndpointAliases)
+	c.EndpointSelectors = append([]string(nil), c.EndpointSelectors...)
+	c.StaticEndpoints = copyEndpoints(c.StaticEndpoints)
+	if c.CSRSubject != nil {
+		subject := copyName(*c.CSRSubject)
+		c.CSRSubject = &subject
+	}
+	if c.OperatorMetadata != nil {
+		metadata := make(map[string]any, len(c.OperatorMetadata))
+		for k, v := range c.OperatorMetadata {
+			metadata[k] = v
+		}
+		c.OperatorMetadata = metadata
+	}
+	return c
+}
+
+// clone returns a copy of c that shares no slices or maps with it.
+func (c DirectConfig) clone() DirectConfig {
+	c.Cert.Certificate = append([][]byte(nil), c.Cert.Certificate...)
+	c.RootCAsPEM = append([]byte(nil), c.RootCAsPEM...)
+	c.AllowHosts = append([]string(nil), c.AllowHosts...)
+	c.DenyHosts = append([]string(nil), c.DenyHosts...)
+	c.DialTimeouts = copyDurations(c.DialTimeouts)
+	c.EndpointAliases = copyStrings(c.EndpointAliases)
+	return c
+}
+
+// copyEndpoints returns a copy of endpoints with copied URLs.
+func copyEndpoints(endpoints []Endpoint) []Endpoint {
+	if endpoints == nil {
+		return nil
+	}
+	c := make([]Endpoint, len(endpoints))
+	for i, ep := range endpoints {
+		if ep.URL != nil {
+			u := *ep.URL
+			ep.URL = &u
+		}
+		c[i] = ep
+	}
+	return c
+}
+
+// copyName returns a copy of name that shares no slices with it.
+func copyName(name pkix.Name) pkix.Name {
+	name.Country = append([]string(nil), name.Country...)
+	name.Organization = append([]string(nil), name.Organization...)
+	name.OrganizationalUnit = append([]string(nil), name.OrganizationalUnit...)
+	name.Locality = append([]string(nil), name.Locality...)
+	name.Province = append([]string(nil), name.Province...)
+	name.StreetAddress = append([]string(nil), name.StreetAddress...)
+	name.PostalCode = append([]string(nil), name.PostalCode...)
+	name.Names = append([]pkix.AttributeTypeAndValue(nil), name.Names...)
+	name.ExtraNames = append([]pkix.AttributeTypeAndValue(nil), name.ExtraNames...)
+	return name
+}
+
+// normalizeIngressEndpoint validates an ingress endpoint and returns it as host:port,
+// defaulting the port to 443 when omitted.
+func normalizeIngressEndpoint(endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		// No port given; retry with the default TLS port
+		host, port, err = net.SplitHostPort(endpoint + ":443")
+		if err != nil {
+			return "", fmt.Errorf("invalid ingress endpoint %q: %w", endpoint, err)
+		}
+	}
+
+	if host == "" {
+		return "", fmt.Errorf("invalid ingress endpoint %q: missing host", endpoint)
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid ingress endpoint %q: invalid port %q", endpoint, port)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// validateProfile checks that a profile name is a single path element.
+func validateProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	if profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		return fmt.Errorf("invalid profile %q: must be a plain directory name", profile)
+	}
+	return nil
+}
+
+// loadRootCAs returns pool with the CA certificates from file and pemCerts
+// appended. pool itself is not modified.
+func loadRootCAs(pool *x509.CertPool, file string, pemCerts []byte) (*x509.CertPool, error) {
+	if file == "" && len(pemCerts) == 0 {
+		return pool, nil
+	}
+
+	if pool == nil {
+		pool = x509.NewCertPool()
+	} else {
+		pool = pool.Clone()
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RootCAsFile: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no CA certificates found in RootCAsFile %s", file)
+		}
+	}
+
+	if len(pemCerts) > 0 && !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, fmt.Errorf("no CA certificates found in RootCAsPEM")
+	}
+
+	return pool, nil
+}
+
+// validateHostPatterns checks that AllowHosts and DenyHosts are valid glob patterns.
+func validateHostPatterns(allow, deny []string) error {
+	for _, pattern := range append(append([]string(nil), allow...), deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid host pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
 }
 
 func defaultDialer() ContextDialer {
@@ -0,0 +1,161 @@
+package ngrokd
+
+import (
+	"crypto/x509/pkix"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeIngressEndpoint(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"kubernetes-binding-ingress.ngrok.io", "kubernetes-binding-ingress.ngrok.io:443", false},
+		{"kubernetes-binding-ingress.ngrok.io:443", "kubernetes-binding-ingress.ngrok.io:443", false},
+		{"ingress.example:8443", "ingress.example:8443", false},
+		{"10.0.0.1", "10.0.0.1:443", false},
+		{"[::1]", "[::1]:443", false},
+		{"[::1]:8443", "[::1]:8443", false},
+		{":443", "", true},
+		{"ingress.example:", "", true},
+		{"ingress.example:https", "", true},
+		{"ingress.example:70000", "", true},
+		{"a:b:c", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := normalizeIngressEndpoint(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeIngressEndpoint(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeIngressEndpoint(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialerNormalizesIngressEndpoint(t *testing.T) {
+	cert := generateTestCert(t)
+
+	d, err := Dialer(DirectConfig{
+		Cert:            cert,
+		IngressEndpoint: "ingress.example",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if d.ingressEndpoint != "ingress.example:443" {
+		t.Errorf("expected port-less ingress endpoint to default to :443, got %s", d.ingressEndpoint)
+	}
+}
+
+func TestDialerRejectsInvalidIngressEndpoint(t *testing.T) {
+	cert := generateTestCert(t)
+
+	_, err := Dialer(DirectConfig{
+		Cert:            cert,
+		IngressEndpoint: ":443",
+	})
+	if err == nil {
+		t.Fatal("expected error for ingress endpoint without host")
+	}
+}
+
+func TestDialerRootCAsFile(t *testing.T) {
+	ingressCert := generateTestCert(t)
+	ingress := newTestIngressWithCert(t, ingressCert, nil)
+	_, caPEM := encodeTestCert(t, ingressCert)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		RootCAsFile:     caFile,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.IngressCAPool() == nil {
+		t.Fatal("expected RootCAsFile to enable ingress verification")
+	}
+
+	conn, err := d.Dial("tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("expected ingress to verify against RootCAsFile, got %v", err)
+	}
+	conn.Close()
+
+	d, err = Dialer(DirectConfig{Cert: generateTestCert(t), RootCAsPEM: caPEM})
+	if err != nil || d.IngressCAPool() == nil {
+		t.Errorf("expected RootCAsPEM to enable ingress verification, got %v", err)
+	}
+}
+
+func TestDialerRejectsInvalidRootCAsFile(t *testing.T) {
+	dir := t.TempDir()
+	badFile := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(badFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	for i, cfg := range []DirectConfig{
+		{RootCAsFile: badFile},
+		{RootCAsFile: filepath.Join(dir, "missing.pem")},
+		{RootCAsPEM: []byte("not a certificate")},
+	} {
+		cfg.Cert = generateTestCert(t)
+		if _, err := Dialer(cfg); err == nil {
+			t.Errorf("case %d: expected error for invalid CA config", i)
+		}
+	}
+}
+
+func TestConfigClone(t *testing.T) {
+	u, _ := url.Parse("http://app.example")
+	cfg := Config{
+		RootCAsPEM:      []byte("roots"),
+		CSRPEM:          []byte("csr"),
+		AllowHosts:      []string{"*.example"},
+		StaticEndpoints: []Endpoint{{ID: "ep_1", URL: u}},
+		CSRSubject:      &pkix.Name{Organization: []string{"acme"}},
+	}
+
+	clone := cfg.clone()
+	clone.RootCAsPEM[0] = 'x'
+	clone.CSRPEM[0] = 'x'
+	clone.AllowHosts[0] = "x"
+	clone.StaticEndpoints[0].URL.Host = "x"
+	clone.CSRSubject.Organization[0] = "x"
+
+	if string(cfg.RootCAsPEM) != "roots" || string(cfg.CSRPEM) != "csr" {
+		t.Errorf("expected PEM fields to be copied, got %q and %q", cfg.RootCAsPEM, cfg.CSRPEM)
+	}
+	if cfg.AllowHosts[0] != "*.example" {
+		t.Errorf("expected AllowHosts to be copied, got %v", cfg.AllowHosts)
+	}
+	if u.Host != "app.example" {
+		t.Errorf("expected static endpoint URLs to be copied, got %s", u)
+	}
+	if cfg.CSRSubject.Organization[0] != "acme" {
+		t.Errorf("expected CSRSubject to be copied, got %v", cfg.CSRSubject)
+	}
+
+	direct := DirectConfig{RootCAsPEM: []byte("roots")}
+	directClone := direct.clone()
+	directClone.RootCAsPEM[0] = 'x'
+	if string(direct.RootCAsPEM) != "roots" {
+		t.Errorf("expected DirectConfig RootCAsPEM to be copied, got %q", direct.RootCAsPEM)
+	}
+}
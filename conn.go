@@ -0,0 +1,200 @@
+package ngrokd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timeoutConn applies idle read and write deadlines to a bound connection.
+// Each Read or Write pushes its deadline forward by the configured timeout,
+// until the caller sets its own deadline for that direction.
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	readDeadlineSet  atomic.Bool
+	writeDeadlineSet atomic.Bool
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 && !c.readDeadlineSet.Load() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 && !c.writeDeadlineSet.Load() {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *timeoutConn) SetDeadline(t time.Time) error {
+	c.readDeadlineSet.Store(true)
+	c.writeDeadlineSet.Store(true)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.readDeadlineSet.Store(true)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *timeoutConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadlineSet.Store(true)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// immediateCloseWindow is how soon after the binding upgrade an EOF on the
+// first Read is reported as a *BackendRefusedError.
+const immediateCloseWindow = 500 * time.Millisecond
+
+// immediateCloseConn reports a bound connection closed without data right
+// after the upgrade as refused by the backend. Like a net.Conn, it expects
+// Reads not to be called concurrently.
+type immediateCloseConn struct {
+	net.Conn
+	hostname   string
+	port       int
+	endpointID string
+	upgradedAt time.Time
+
+	read bool
+}
+
+func (c *immediateCloseConn) Read(b []byte) (int, error) {
+	if c.read {
+		return c.Conn.Read(b)
+	}
+	c.read = true
+
+	n, err := c.Conn.Read(b)
+	if n == 0 && err == io.EOF && time.Since(c.upgradedAt) < immediateCloseWindow {
+		return 0, &BackendRefusedError{Hostname: c.hostname, Port: c.port, EndpointID: c.endpointID}
+	}
+	return n, err
+}
+
+// ReconnectingConn is a bound connection that re-dials its endpoint when a
+// Read or Write fails, e.g. after an ingress restart. The failing call returns
+// an error wrapping both ErrReconnectable and the original error, and later
+// calls use the new connection.
+//
+// Data in flight when the connection dropped is lost and the backend sees a
+// new connection, so only use it for protocols that can recover from that,
+// e.g. by re-sending a greeting. Deadlines are not carried over to the new
+// connection. io.EOF counts as a drop, since an ingress going away closes
+// cleanly; callers for whom EOF means the backend is done should Close on
+// errors.Is(err, io.EOF). Timeouts are returned as-is without reconnecting.
+// Close aborts a reconnect in progress.
+type ReconnectingConn struct {
+	dial   func(ctx context.Context) (net.Conn, error)
+	ctx    context.Context // canceled by Close
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func (c *ReconnectingConn) current() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *ReconnectingConn) Read(b []byte) (int, error) {
+	conn := c.current()
+	n, err := conn.Read(b)
+	if err != nil {
+		err = c.reconnect(conn, err)
+	}
+	return n, err
+}
+
+func (c *ReconnectingConn) Write(b []byte) (int, error) {
+	conn := c.current()
+	n, err := conn.Write(b)
+	if err != nil {
+		err = c.reconnect(conn, err)
+	}
+	return n, err
+}
+
+// reconnect replaces failed with a new connection if err indicates a dropped
+// connection, and returns the error for the failed call.
+func (c *ReconnectingConn) reconnect(failed net.Conn, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return err
+	}
+
+	c.mu.Lock()
+	closed, replaced := c.closed, c.conn != failed
+	c.mu.Unlock()
+	if closed {
+		return err
+	}
+	if replaced {
+		// A concurrent call already reconnected
+		return fmt.Errorf("%w: %w", ErrReconnectable, err)
+	}
+
+	// Dial without holding mu so Close and the other methods don't wait on it
+	conn, dialErr := c.dial(c.ctx)
+	if dialErr != nil {
+		return fmt.Errorf("%w (reconnect failed: %w)", err, dialErr)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		conn.Close()
+		return err
+	}
+	if c.conn != failed {
+		conn.Close()
+		return fmt.Errorf("%w: %w", ErrReconnectable, err)
+	}
+	failed.Close()
+	c.conn = conn
+
+	return fmt.Errorf("%w: %w", ErrReconnectable, err)
+}
+
+func (c *ReconnectingConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cancel()
+	return c.conn.Close()
+}
+
+func (c *ReconnectingConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *ReconnectingConn) RemoteAddr() net.Addr {
+	return c.current().RemoteAddr()
+}
+
+func (c *ReconnectingConn) SetDeadline(t time.Time) error {
+	return c.current().SetDeadline(t)
+}
+
+func (c *ReconnectingConn) SetReadDeadline(t time.Time) error {
+	return c.current().SetReadDeadline(t)
+}
+
+func (c *ReconnectingConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
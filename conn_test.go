@@ -0,0 +1,369 @@
+package ngrokd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnReadTimeout(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		ConnReadTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.Dial("tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The test ingress echoes, so a write followed by a read succeeds
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	// Nothing more is sent, so the next read stalls until the idle timeout
+	start := time.Now()
+	_, err = conn.Read(buf)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stalled read took %v, expected ~50ms", elapsed)
+	}
+}
+
+func TestConnReadTimeoutOverriddenByDeadline(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		ConnReadTimeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.Dial("tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("read returned after %v; caller deadline should replace the idle timeout", elapsed)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+	recorder := &keepAliveDialer{}
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		IngressDialer:   recorder,
+		KeepAlive:       45 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.Dial("tcp", "db.example:5432")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if !recorder.conn.enabled {
+		t.Error("expected keep-alive to be enabled on the underlying socket")
+	}
+	if recorder.conn.period != 45*time.Second {
+		t.Errorf("expected keep-alive period 45s, got %v", recorder.conn.period)
+	}
+}
+
+// keepAliveDialer dials TCP and records keep-alive settings applied to the conn.
+type keepAliveDialer struct {
+	conn *keepAliveConn
+}
+
+func (d *keepAliveDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	d.conn = &keepAliveConn{TCPConn: conn.(*net.TCPConn)}
+	return d.conn, nil
+}
+
+type keepAliveConn struct {
+	*net.TCPConn
+	enabled bool
+	period  time.Duration
+}
+
+func (c *keepAliveConn) SetKeepAlive(enabled bool) error {
+	c.enabled = enabled
+	return c.TCPConn.SetKeepAlive(enabled)
+}
+
+func (c *keepAliveConn) SetKeepAlivePeriod(period time.Duration) error {
+	c.period = period
+	return c.TCPConn.SetKeepAlivePeriod(period)
+}
+
+func TestReconnectingConn(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+	recorder := &keepAliveDialer{}
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		IngressDialer:   recorder,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.DialReconnecting(context.Background(), "db.example:5432")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Drop the socket under the bound connection mid-stream
+	recorder.conn.Close()
+
+	buf := make([]byte, 5)
+	if _, err := conn.Read(buf); !errors.Is(err, ErrReconnectable) {
+		t.Fatalf("expected ErrReconnectable after the drop, got %v", err)
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write after reconnect failed: %v", err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("expected echo over the new connection, got %q (%v)", buf, err)
+	}
+
+	if n := len(ingress.Requests()); n != 2 {
+		t.Errorf("expected 2 binding requests, got %d", n)
+	}
+}
+
+func TestDetectImmediateClose(t *testing.T) {
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http", Close: req.Host == "refused.example"}
+	})
+
+	dial := func(detect bool, address string) net.Conn {
+		t.Helper()
+		d, err := Dialer(DirectConfig{
+			Cert:                 generateTestCert(t),
+			IngressEndpoint:      ingress.addr,
+			DetectImmediateClose: detect,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		conn, err := d.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	_, err := dial(true, "refused.example:80").Read(make([]byte, 1))
+	var refused *BackendRefusedError
+	if !errors.As(err, &refused) {
+		t.Fatalf("expected BackendRefusedError, got %v", err)
+	}
+	if refused.Hostname != "refused.example" || refused.Port != 80 || refused.EndpointID != "ep_refused.example" {
+		t.Errorf("unexpected error fields: %+v", refused)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Error("expected BackendRefusedError to wrap io.EOF")
+	}
+
+	if _, err := dial(false, "refused.example:80").Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected bare io.EOF without DetectImmediateClose, got %v", err)
+	}
+
+	// A working connection is unaffected
+	conn := dial(true, "app.example:80")
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("expected echo, got %q, %v", buf, err)
+	}
+}
+
+func TestReconnectingConnEOF(t *testing.T) {
+	var requests atomic.Int32
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		// The first connection is closed cleanly by the ingress
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "tcp", Close: requests.Add(1) == 1}
+	})
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.DialReconnecting(context.Background(), "db.example:5432")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	if !errors.Is(err, ErrReconnectable) || !errors.Is(err, io.EOF) {
+		t.Fatalf("expected ErrReconnectable wrapping io.EOF, got %v", err)
+	}
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write after reconnect failed: %v", err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("expected echo over the new connection, got %q (%v)", buf, err)
+	}
+}
+
+func TestReconnectingConnDialTimeout(t *testing.T) {
+	// Every connection is dropped right after binding, forcing a re-dial
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "tcp", Close: true}
+	})
+
+	tests := []struct {
+		name         string
+		dialTimeouts map[string]time.Duration
+		want         time.Duration
+	}{
+		{"default", nil, reconnectTimeout},
+		{"dial timeout", map[string]time.Duration{"db.example": time.Hour}, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &deadlineRecorder{}
+			d, err := Dialer(DirectConfig{
+				Cert:            generateTestCert(t),
+				IngressEndpoint: ingress.addr,
+				IngressDialer:   recorder,
+				DialTimeouts:    tt.dialTimeouts,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			conn, err := d.DialReconnecting(context.Background(), "db.example:5432")
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			start := time.Now()
+			if _, err := conn.Read(make([]byte, 5)); !errors.Is(err, ErrReconnectable) {
+				t.Fatalf("expected ErrReconnectable after the drop, got %v", err)
+			}
+
+			want := start.Add(tt.want)
+			if got := recorder.Deadlines()[1]; got.Before(want.Add(-time.Second)) || got.After(want.Add(time.Second)) {
+				t.Errorf("expected a re-dial deadline of about %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestReconnectingConnCloseAbortsReconnect(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var requests atomic.Int32
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		if requests.Add(1) > 1 {
+			<-release
+		}
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "tcp"}
+	})
+	recorder := &keepAliveDialer{}
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		IngressDialer:   recorder,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.DialReconnecting(context.Background(), "db.example:5432")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	recorder.conn.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 5))
+		readErr <- err
+	}()
+	for requests.Load() < 2 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The re-dial is stuck in the binding upgrade; Close must not wait for it
+	closed := make(chan struct{})
+	go func() {
+		conn.LocalAddr()
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on the reconnect")
+	}
+
+	select {
+	case err := <-readErr:
+		if err == nil || errors.Is(err, ErrReconnectable) {
+			t.Errorf("expected the read to fail without reconnecting, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not abort the reconnect")
+	}
+}
@@ -2,29 +2,41 @@ package ngrokd
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-logr/logr"
 )
 
 // dialer provides simple net.Dial-like access to ngrok endpoints.
 type dialer struct {
-	tlsConfig       *tls.Config
-	ingressEndpoint string
-	ingressDialer   ContextDialer
-	rootCAs         *x509.CertPool
-	logger          logr.Logger
+	*binder
+	cfg        DirectConfig
+	operatorID string
 }
 
 // Dialer creates a dialer for direct connections to ngrok endpoints.
 // If no Cert is provided, loads from CertStore (default: ~/.ngrokd-go/certs).
 func Dialer(cfg DirectConfig) (*dialer, error) {
-	cfg.setDefaults()
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
 
 	var cert tls.Certificate
+	var operatorID string
 	if cfg.Cert.Certificate != nil {
 		cert = cfg.Cert
 	} else {
@@ -35,10 +47,15 @@ func Dialer(cfg DirectConfig) (*dialer, error) {
 			return nil, fmt.Errorf("failed to check cert store: %w", err)
 		}
 		if !exists {
-			return nil, fmt.Errorf("no certificate found; provision with DiscoveryDialer first or provide Cert")
+			where := fmt.Sprintf("%T", cfg.CertStore)
+			if fs, ok := cfg.CertStore.(*FileStore); ok {
+				where = fs.dir()
+			}
+			return nil, fmt.Errorf("no certificate found in %s; provision with DiscoveryDialer first or provide Cert", where)
 		}
 
-		keyPEM, certPEM, _, err := cfg.CertStore.Load(ctx)
+		var keyPEM, certPEM []byte
+		keyPEM, certPEM, operatorID, err = cfg.CertStore.Load(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load certificate: %w", err)
 		}
@@ -49,15 +66,63 @@ func Dialer(cfg DirectConfig) (*dialer, error) {
 		}
 	}
 
+	cfg.Cert = cert
+
 	return &dialer{
-		tlsConfig:       buildTLSConfig(cert, cfg.RootCAs),
-		ingressEndpoint: cfg.IngressEndpoint,
-		ingressDialer:   cfg.IngressDialer,
-		rootCAs:         cfg.RootCAs,
-		logger:          cfg.Logger,
+		cfg:        cfg,
+		operatorID: operatorID,
+		binder: &binder{
+			tlsConfig:               buildTLSConfig(cert, cfg.RootCAs),
+			ingressEndpoint:         cfg.IngressEndpoint,
+			ingressDialer:           cfg.IngressDialer,
+			rootCAs:                 cfg.RootCAs,
+			onIngressCertChange:     cfg.OnIngressCertChange,
+			onProtoMismatch:         cfg.OnProtoMismatch,
+			logger:                  cfg.Logger,
+			hostnameRewrite:         cfg.HostnameRewrite,
+			endpointAliases:         copyStrings(cfg.EndpointAliases),
+			allowHosts:              cfg.AllowHosts,
+			denyHosts:               cfg.DenyHosts,
+			dialTimeouts:            copyDurations(cfg.DialTimeouts),
+			readTimeout:             cfg.ConnReadTimeout,
+			writeTimeout:            cfg.ConnWriteTimeout,
+			keepAlive:               cfg.KeepAlive,
+			renewBefore:             cfg.RenewBefore,
+			detectImmediateClose:    cfg.DetectImmediateClose,
+			captureFailedHandshakes: cfg.CaptureFailedHandshakes,
+		},
 	}, nil
 }
 
+// Clone returns a new dialer that reuses this dialer's certificate, with
+// mutate applied to a copy of its configuration.
+func (d *dialer) Clone(mutate func(*DirectConfig)) (*dialer, error) {
+	cfg := d.cfg.clone()
+	if mutate != nil {
+		mutate(&cfg)
+	}
+	return Dialer(cfg)
+}
+
+// ConfigSummary returns the effective configuration after defaults, for
+// diagnostics. Key material is never included.
+func (d *dialer) ConfigSummary() string {
+	var sb strings.Builder
+	d.writeSummary(&sb)
+	fmt.Fprintf(&sb, "cert_store: %T\n", d.cfg.CertStore)
+	return sb.String()
+}
+
+// ExportIdentity returns the dialer's certificate chain and private key as PEM,
+// and the operator ID if the certificate was loaded from a CertStore.
+//
+// The key grants access to every endpoint the operator can reach. Handle it
+// as a secret: never log it, and store it only where other credentials live.
+func (d *dialer) ExportIdentity() (certPEM, keyPEM []byte, operatorID string, err error) {
+	certPEM, keyPEM, err = d.exportCert()
+	return certPEM, keyPEM, d.operatorID, err
+}
+
 // Dial connects to the address via ngrok.
 func (d *dialer) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
@@ -65,76 +130,364 @@ func (d *dialer) Dial(network, address string) (net.Conn, error) {
 
 // DialContext connects to the address via ngrok with context.
 func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	hostname, port, err := parseAddress(address)
-	if err != nil {
-		return nil, fmt.Errorf("invalid address %q: %w", address, err)
-	}
-
-	if d.logger.Enabled() {
-		d.logger.V(1).Info("Dialing via ngrok", "hostname", hostname, "port", port)
-	}
+	conn, _, err := d.dialAddress(ctx, address)
+	return conn, err
+}
 
-	return dialNgrok(ctx, d.ingressDialer, d.ingressEndpoint, d.tlsConfig, d.rootCAs, hostname, port, d.logger)
+// DialContextInfo is like DialContext, and also returns what the ingress
+// reported about the bound endpoint.
+func (d *dialer) DialContextInfo(ctx context.Context, network, address string) (net.Conn, DialInfo, error) {
+	return d.dialAddress(ctx, address)
 }
 
+// defaultWaitInterval is how often WaitForEndpoint re-discovers endpoints.
+const defaultWaitInterval = 2 * time.Second
+
 // discoveryDialer provides net.Dial-like access with API-based cert provisioning and visibility.
 type discoveryDialer struct {
-	tlsConfig       *tls.Config
-	ingressEndpoint string
-	ingressDialer   ContextDialer
-	rootCAs         *x509.CertPool
-	logger          logr.Logger
-	operatorID      string
-	apiClient       *apiClient
+	*binder
+	cfg Config
+
+	// operatorID and operatorRaw are set once provisioned is true, and
+	// replaced under provisionMu if revalidation re-provisions.
+	// revalidateAt is when the operator is next checked, in Unix nanoseconds.
+	provisionMu  sync.Mutex
+	provisioned  atomic.Bool
+	operatorID   string
+	operatorRaw  json.RawMessage
+	revalidateAt atomic.Int64
+
+	apiClient           *apiClient
+	minDiscoverInterval time.Duration
+	discoveryTimeout    time.Duration
+	waitInterval        time.Duration
+
+	// discoverMu serializes discoveries; endpointsMu guards their result
+	// so dials never wait on an in-flight API call.
+	discoverMu    sync.Mutex
+	endpointsMu   sync.RWMutex
+	endpoints     []Endpoint
+	lastDiscovery time.Time
 }
 
 // DiscoveryDialer creates a dialer with API-based cert provisioning and endpoint visibility.
-// Requires an API key for provisioning certificates. Use Endpoints() or Diagnose() to see available endpoints.
+// Requires an API key for provisioning certificates, unless Cert and EndpointSource are both set.
+// Use Endpoints() or Diagnose() to see available endpoints.
 func DiscoveryDialer(ctx context.Context, cfg Config) (*discoveryDialer, error) {
-	if cfg.APIKey == "" {
-		return nil, fmt.Errorf("APIKey is required; use Dialer for direct connections")
+	if !cfg.hasAPIKey() && (cfg.Cert.Certificate == nil || cfg.EndpointSource == nil) {
+		return nil, fmt.Errorf("APIKey or APIKeyProvider is required unless Cert and EndpointSource are set; use Dialer for direct connections")
+	}
+
+	if err := cfg.setDefaults(); err != nil {
+		return nil, err
+	}
+
+	d := &discoveryDialer{
+		cfg: cfg,
+		binder: &binder{
+			ingressEndpoint:         cfg.IngressEndpoint,
+			ingressDialer:           cfg.IngressDialer,
+			rootCAs:                 cfg.RootCAs,
+			onIngressCertChange:     cfg.OnIngressCertChange,
+			onProtoMismatch:         cfg.OnProtoMismatch,
+			logger:                  cfg.Logger,
+			hostnameRewrite:         cfg.HostnameRewrite,
+			endpointAliases:         copyStrings(cfg.EndpointAliases),
+			allowHosts:              cfg.AllowHosts,
+			denyHosts:               cfg.DenyHosts,
+			dialTimeouts:            copyDurations(cfg.DialTimeouts),
+			readTimeout:             cfg.ConnReadTimeout,
+			writeTimeout:            cfg.ConnWriteTimeout,
+			keepAlive:               cfg.KeepAlive,
+			renewBefore:             cfg.RenewBefore,
+			detectImmediateClose:    cfg.DetectImmediateClose,
+			captureFailedHandshakes: cfg.CaptureFailedHandshakes,
+			quarantine:              newQuarantine(cfg.Quarantine, cfg.Logger),
+		},
+		apiClient:           newAPIClient(cfg.APIKey),
+		minDiscoverInterval: cfg.MinDiscoverInterval,
+		discoveryTimeout:    cfg.DiscoveryTimeout,
+		waitInterval:        defaultWaitInterval,
+	}
+	d.apiClient.keyProvider = cfg.APIKeyProvider
+	d.portLookup = d.lookupPort
+	d.prepare = d.ensureProvisioned
+	if cfg.DialAuthorizer != nil {
+		d.authorize = d.authorizeDial
+	}
+	if cfg.BindingRequestHook != nil {
+		d.requestHook = func(req *ConnRequest) {
+			cfg.BindingRequestHook(req, d.resolveEndpoint(req.Host, req.Port))
+		}
+	}
+
+	// Zero time so static endpoints never delay the first discovery
+	d.setEndpoints(append([]Endpoint(nil), cfg.StaticEndpoints...), time.Time{})
+
+	startCtx := ctx
+	if cfg.StartupTimeout > 0 {
+		var cancel context.CancelFunc
+		startCtx, cancel = context.WithTimeout(ctx, cfg.StartupTimeout)
+		defer cancel()
+	}
+	// Only the startup budget expiring counts; the caller's ctx ending does not
+	timedOut := func() bool {
+		return errors.Is(startCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+	}
+
+	if !cfg.LazyProvision {
+		if err := d.ensureProvisioned(startCtx); err != nil {
+			if !timedOut() {
+				return nil, err
+			}
+			err = fmt.Errorf("startup exceeded StartupTimeout of %v: %w", cfg.StartupTimeout, err)
+			if !cfg.IgnoreInitialDiscoveryError {
+				return nil, err
+			}
+			if d.logger.Enabled() {
+				d.logger.Info("Startup timed out; provisioning and discovery will be retried on next use", "error", err.Error())
+			}
+			return d, nil
+		}
+	}
+
+	if cfg.DiscoverOnStart {
+		if _, err := d.discover(startCtx, true); err != nil {
+			if timedOut() {
+				err = fmt.Errorf("startup exceeded StartupTimeout of %v: %w", cfg.StartupTimeout, err)
+			}
+			if !cfg.IgnoreInitialDiscoveryError {
+				return nil, fmt.Errorf("initial discovery failed: %w", err)
+			}
+			if d.logger.Enabled() {
+				d.logger.Info("Initial discovery failed; endpoints will be discovered on next use", "error", err.Error())
+			}
+		}
 	}
 
-	cfg.setDefaults()
+	return d, nil
+}
+
+// ensureProvisioned loads or provisions the certificate and operator on first
+// use. A failed attempt is retried by the next caller.
+func (d *discoveryDialer) ensureProvisioned(ctx context.Context) error {
+	if d.provisioned.Load() && !d.revalidateDue() {
+		return nil
+	}
 
-	apiClient := newAPIClient(cfg.APIKey)
+	d.provisionMu.Lock()
+	defer d.provisionMu.Unlock()
+	if d.provisioned.Load() {
+		if d.revalidateDue() {
+			return d.revalidate(ctx)
+		}
+		return nil
+	}
 
-	// Use provided cert/operator, or provision/load from store
+	// Use provided cert/operator, or provision/load from store, as preferred
 	var tlsCert tls.Certificate
 	var operatorID string
+	var err error
+	supplied := d.cfg.Cert.Certificate != nil
+	provisioner := newCertProvisioner(d.cfg, d.apiClient)
 
-	if cfg.Cert.Certificate != nil {
-		tlsCert = cfg.Cert
-		operatorID = cfg.OperatorID
-	} else {
-		provisioner := newCertProvisioner(cfg.CertStore, apiClient, cfg.EndpointSelectors)
-		var err error
+	switch {
+	case d.cfg.CertPreference == CertPreferProvision:
+		tlsCert, operatorID, err = provisioner.provisionCertificate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to provision certificate: %w", err)
+		}
+	case supplied && d.cfg.CertPreference == CertPreferStore:
+		var ok bool
+		tlsCert, operatorID, ok, err = provisioner.loadCertificate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		if !ok {
+			tlsCert = d.cfg.Cert
+		}
+	case supplied:
+		tlsCert = d.cfg.Cert
+	default:
 		tlsCert, operatorID, err = provisioner.EnsureCertificate(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("failed to provision certificate: %w", err)
+			return fmt.Errorf("failed to provision certificate: %w", err)
 		}
 	}
+	d.operatorRaw = provisioner.operatorRaw
 
 	// Allow overriding operator ID even with provisioned cert
-	if cfg.OperatorID != "" {
-		operatorID = cfg.OperatorID
+	if d.cfg.OperatorID != "" {
+		operatorID = d.cfg.OperatorID
 	}
 
-	d := &discoveryDialer{
-		tlsConfig:       buildTLSConfig(tlsCert, cfg.RootCAs),
-		ingressEndpoint: cfg.IngressEndpoint,
-		ingressDialer:   cfg.IngressDialer,
-		rootCAs:         cfg.RootCAs,
-		logger:          cfg.Logger,
-		operatorID:      operatorID,
-		apiClient:       apiClient,
+	if d.cfg.TrustOperatorChain {
+		if err := d.trustChain(tlsCert); err != nil {
+			return err
+		}
 	}
 
+	d.operatorID = operatorID
+	d.setClientCert(tlsCert)
+	d.scheduleRevalidation()
+	d.provisioned.Store(true)
+
 	if d.logger.Enabled() {
-		d.logger.Info("Certificate ready", "operatorID", d.operatorID)
+		d.logger.Info("Certificate ready", "operatorID", operatorID)
 	}
 
-	return d, nil
+	return nil
+}
+
+// revalidateDue reports whether CertRevalidateInterval has passed since the
+// operator was last provisioned or checked.
+func (d *discoveryDialer) revalidateDue() bool {
+	at := d.revalidateAt.Load()
+	return at != 0 && time.Now().UnixNano() >= at
+}
+
+func (d *discoveryDialer) scheduleRevalidation() {
+	if d.cfg.CertRevalidateInterval > 0 {
+		d.revalidateAt.Store(time.Now().Add(d.cfg.CertRevalidateInterval).UnixNano())
+	}
+}
+
+// revalidate checks that the operator still exists and applies
+// MissingOperatorPolicy if it was deleted. Other failures leave the current
+// identity in place until the next check. Under MissingOperatorError the check
+// is not rescheduled, so every use fails until the operator exists again.
+// Called with provisionMu held.
+func (d *discoveryDialer) revalidate(ctx context.Context) error {
+	if d.operatorID == "" {
+		d.scheduleRevalidation()
+		return nil
+	}
+
+	_, err := d.apiClient.GetOperator(ctx, d.operatorID)
+	if err == nil {
+		d.scheduleRevalidation()
+		return nil
+	}
+	if !errors.Is(err, ErrOperatorNotFound) {
+		if d.logger.Enabled() {
+			d.logger.Info("Operator revalidation failed; keeping current certificate", "operatorID", d.operatorID, "error", err.Error())
+		}
+		d.scheduleRevalidation()
+		return nil
+	}
+
+	switch d.cfg.missingOperatorPolicy() {
+	case MissingOperatorError:
+		return err
+	case MissingOperatorReuseLocal:
+		if d.logger.Enabled() {
+			d.logger.Info("Operator no longer exists; keeping current certificate", "operatorID", d.operatorID)
+		}
+		d.scheduleRevalidation()
+		return nil
+	}
+
+	defer d.scheduleRevalidation()
+
+	provisioner := newCertProvisioner(d.cfg, d.apiClient)
+	tlsCert, operatorID, err := provisioner.provisionCertificate(ctx)
+	if err != nil {
+		if d.logger.Enabled() {
+			d.logger.Error(err, "Failed to re-provision after operator was deleted", "operatorID", d.operatorID)
+		}
+		return nil
+	}
+
+	if d.logger.Enabled() {
+		d.logger.Info("Operator no longer exists; re-provisioned", "previousOperatorID", d.operatorID, "operatorID", operatorID)
+	}
+	if d.cfg.TrustOperatorChain {
+		if err := d.trustChain(tlsCert); err != nil && d.logger.Enabled() {
+			d.logger.Error(err, "Failed to trust operator chain")
+		}
+	}
+	d.operatorID = operatorID
+	d.operatorRaw = provisioner.operatorRaw
+	d.setClientCert(tlsCert)
+	return nil
+}
+
+// identity returns the operator ID and raw operator, which revalidation may replace.
+func (d *discoveryDialer) identity() (string, json.RawMessage) {
+	d.provisionMu.Lock()
+	defer d.provisionMu.Unlock()
+	return d.operatorID, d.operatorRaw
+}
+
+// Clone returns a new dialer that reuses this dialer's certificate, operator
+// and discovered endpoints, with mutate applied to a copy of its configuration.
+// The clone never provisions, unless this dialer is lazy and has not provisioned yet.
+func (d *discoveryDialer) Clone(mutate func(*Config)) (*discoveryDialer, error) {
+	cfg := d.cfg.clone()
+	if d.provisioned.Load() {
+		// Current identity, including any RotateKey
+		cfg.Cert = d.clientTLSConfig().Certificates[0]
+		cfg.OperatorID, _ = d.identity()
+		cfg.CertPreference = CertPreferSupplied
+	}
+	// The clone takes over the endpoints discovered so far
+	cfg.DiscoverOnStart = false
+	if mutate != nil {
+		mutate(&cfg)
+	}
+
+	clone, err := DiscoveryDialer(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Providers cannot be compared, so only a static key shares the client
+	if cfg.APIKey == d.cfg.APIKey && cfg.APIKeyProvider == nil && d.cfg.APIKeyProvider == nil {
+		clone.apiClient = d.apiClient
+	}
+
+	endpoints, lastDiscovery := d.cachedEndpoints()
+	clone.setEndpoints(endpoints, lastDiscovery)
+
+	if operatorID, raw := d.identity(); d.provisioned.Load() && clone.OperatorID() == operatorID {
+		clone.operatorRaw = raw
+	}
+
+	return clone, nil
+}
+
+// ConfigSummary returns the effective configuration after defaults, for
+// diagnostics. The API key and key material are never included.
+func (d *discoveryDialer) ConfigSummary() string {
+	var sb strings.Builder
+	d.writeSummary(&sb)
+	fmt.Fprintf(&sb, "cert_store: %T\n", d.cfg.CertStore)
+	if d.cfg.APIKeyProvider != nil {
+		fmt.Fprintf(&sb, "api_key: (provider)\n")
+	} else {
+		fmt.Fprintf(&sb, "api_key: %s\n", redact(d.cfg.APIKey))
+	}
+	fmt.Fprintf(&sb, "operator_id: %s\n", d.OperatorID())
+	fmt.Fprintf(&sb, "lazy_provision: %t\n", d.cfg.LazyProvision)
+	fmt.Fprintf(&sb, "cert_preference: %s\n", d.cfg.CertPreference)
+	fmt.Fprintf(&sb, "endpoint_selectors: %v\n", d.cfg.EndpointSelectors)
+	fmt.Fprintf(&sb, "min_discover_interval: %s\n", d.minDiscoverInterval)
+	fmt.Fprintf(&sb, "discovery_timeout: %s\n", d.discoveryTimeout)
+	return sb.String()
+}
+
+// ExportIdentity returns the dialer's certificate chain and private key as PEM,
+// and its operator ID, e.g. to hand the identity to another tool.
+//
+// The key grants access to every endpoint the operator can reach. Handle it
+// as a secret: never log it, and store it only where other credentials live.
+func (d *discoveryDialer) ExportIdentity() (certPEM, keyPEM []byte, operatorID string, err error) {
+	if err := d.ensureProvisioned(context.Background()); err != nil {
+		return nil, nil, "", err
+	}
+	certPEM, keyPEM, err = d.exportCert()
+	operatorID, _ = d.identity()
+	return certPEM, keyPEM, operatorID, err
 }
 
 // Dial connects to the address via ngrok.
@@ -144,68 +497,898 @@ func (d *discoveryDialer) Dial(network, address string) (net.Conn, error) {
 
 // DialContext connects to the address via ngrok with context.
 func (d *discoveryDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	hostname, port, err := parseAddress(address)
+	conn, _, err := d.dialAddress(ctx, address)
+	return conn, err
+}
+
+// DialContextInfo is like DialContext, and also returns what the ingress
+// reported about the bound endpoint.
+func (d *discoveryDialer) DialContextInfo(ctx context.Context, network, address string) (net.Conn, DialInfo, error) {
+	return d.dialAddress(ctx, address)
+}
+
+// OperatorID returns the ngrok operator ID.
+// With LazyProvision it is empty until the dialer is first used.
+func (d *discoveryDialer) OperatorID() string {
+	if !d.provisioned.Load() {
+		return ""
+	}
+	operatorID, _ := d.identity()
+	return operatorID
+}
+
+// RotateKey replaces the private key while keeping the operator: a CSR for a
+// new key is submitted to the existing operator, and the issued certificate is
+// saved to the CertStore and used for subsequent dials. Existing connections
+// are not affected.
+func (d *discoveryDialer) RotateKey(ctx context.Context) error {
+	if err := d.ensureProvisioned(ctx); err != nil {
+		return err
+	}
+	operatorID, _ := d.identity()
+	if operatorID == "" {
+		return fmt.Errorf("operator ID not set")
+	}
+
+	cert, err := newCertProvisioner(d.cfg, d.apiClient).rotateKey(ctx, operatorID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		return fmt.Errorf("failed to rotate key: %w", err)
 	}
 
+	d.setClientCert(cert)
+
 	if d.logger.Enabled() {
-		d.logger.V(1).Info("Dialing via ngrok", "hostname", hostname, "port", port)
+		d.logger.Info("Rotated key", "operatorID", operatorID)
 	}
 
-	return dialNgrok(ctx, d.ingressDialer, d.ingressEndpoint, d.tlsConfig, d.rootCAs, hostname, port, d.logger)
+	return nil
 }
 
-// OperatorID returns the ngrok operator ID.
-func (d *discoveryDialer) OperatorID() string {
-	return d.operatorID
+// OperatorRaw returns the raw API response from creating the operator, for
+// fields the SDK does not model. It is nil unless this dialer provisioned
+// the operator; identities loaded from a CertStore do not keep it.
+func (d *discoveryDialer) OperatorRaw() json.RawMessage {
+	if !d.provisioned.Load() {
+		return nil
+	}
+	_, raw := d.identity()
+	return append(json.RawMessage(nil), raw...)
+}
+
+// OperatorMetadata unmarshals the JSON metadata of the operator this dialer
+// provisioned into v, e.g. as set by Config.OperatorMetadata. Like OperatorRaw,
+// it leaves v untouched unless this dialer provisioned the operator.
+func (d *discoveryDialer) OperatorMetadata(v any) error {
+	raw := d.OperatorRaw()
+	if raw == nil {
+		return nil
+	}
+	var operator operatorResponse
+	if err := json.Unmarshal(raw, &operator); err != nil {
+		return fmt.Errorf("invalid operator response: %w", err)
+	}
+	return operator.DecodeMetadata(v)
 }
 
-// Endpoints fetches bound endpoints from ngrok API.
+// Endpoints fetches bound endpoints from ngrok API, or from Config.EndpointSource if set.
+// Within MinDiscoverInterval of the last successful discovery, the previous result is returned.
 func (d *discoveryDialer) Endpoints(ctx context.Context) ([]Endpoint, error) {
-	return discoverEndpoints(ctx, d.apiClient, d.operatorID)
+	return d.discover(ctx, false)
+}
+
+// ForceRefresh fetches endpoints like Endpoints, ignoring MinDiscoverInterval.
+func (d *discoveryDialer) ForceRefresh(ctx context.Context) ([]Endpoint, error) {
+	return d.discover(ctx, true)
+}
+
+// WaitForEndpoint blocks until an endpoint with the given hostname is discovered
+// or ctx is done. Discovery errors while waiting are logged and retried.
+func (d *discoveryDialer) WaitForEndpoint(ctx context.Context, hostname string) (Endpoint, error) {
+	ticker := time.NewTicker(d.waitInterval)
+	defer ticker.Stop()
+
+	for {
+		endpoints, err := d.Endpoints(ctx)
+		if err == nil {
+			for _, ep := range endpoints {
+				if strings.EqualFold(ep.Hostname(), hostname) {
+					return ep, nil
+				}
+			}
+		} else if d.logger.Enabled() {
+			d.logger.V(1).Info("Discovery failed while waiting for endpoint", "hostname", hostname, "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return Endpoint{}, fmt.Errorf("waiting for endpoint %s: %w (last discovery error: %v)", hostname, ctx.Err(), err)
+			}
+			return Endpoint{}, fmt.Errorf("waiting for endpoint %s: %w", hostname, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *discoveryDialer) discover(ctx context.Context, force bool) ([]Endpoint, error) {
+	if err := d.ensureProvisioned(ctx); err != nil {
+		return nil, err
+	}
+
+	endpoints, emptied, err := d.discoverLocked(ctx, force)
+	if err != nil {
+		return nil, err
+	}
+
+	// Called after unlocking so the hook may use the dialer
+	if emptied && d.cfg.OnCacheEmpty != nil {
+		d.cfg.OnCacheEmpty()
+	}
+
+	return endpoints, nil
 }
 
+// discoverLocked performs a discovery under discoverMu, reporting whether it
+// emptied a cache that held endpoints.
+func (d *discoveryDialer) discoverLocked(ctx context.Context, force bool) ([]Endpoint, bool, error) {
+	// Held across the API call so concurrent callers share one discovery
+	d.discoverMu.Lock()
+	defer d.discoverMu.Unlock()
+
+	cached, lastDiscovery := d.cachedEndpoints()
+	if !force && d.minDiscoverInterval > 0 && !lastDiscovery.IsZero() &&
+		time.Since(lastDiscovery) < d.minDiscoverInterval {
+		return cached, false, nil
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.discoveryTimeout)
+		defer cancel()
+	}
+
+	endpoints, err := d.endpointSource().List(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if d.cfg.EndpointSource != nil {
+		// API endpoints are normalized as they are parsed
+		if endpoints, err = normalizeEndpoints("source", endpoints); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if d.cfg.VerifyOnDiscover {
+		d.verifyEndpoints(ctx, endpoints)
+	}
+	endpoints = overlayEndpoints(endpoints, d.cfg.StaticEndpoints)
+
+	d.setEndpoints(endpoints, time.Now())
+
+	emptied := len(endpoints) == 0 && len(cached) > 0
+	return append([]Endpoint(nil), endpoints...), emptied, nil
+}
+
+// verifyEndpoints probes each endpoint and sets its Reachable flag.
+func (d *discoveryDialer) verifyEndpoints(ctx context.Context, endpoints []Endpoint) {
+	forEachBounded(len(endpoints), d.cfg.BulkDialConcurrency, func(i int) {
+		ep := &endpoints[i]
+		port, ok := ep.port()
+		if !ok {
+			return
+		}
+
+		conn, _, err := d.dial(ctx, ep.Hostname(), port)
+		if err != nil {
+			if d.logger.Enabled() {
+				d.logger.V(1).Info("Endpoint unreachable", "endpointID", ep.ID, "error", err.Error())
+			}
+			return
+		}
+		conn.Close()
+		ep.Reachable = true
+	})
+}
+
+// forEachBounded calls fn for each index in [0, n), running at most limit
+// calls concurrently, and returns once all have finished. Bulk dialing
+// features use it so they share one concurrency bound.
+func forEachBounded(n, limit int, fn func(i int)) {
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// endpointSource returns the configured EndpointSource, or the ngrok API.
+func (d *discoveryDialer) endpointSource() EndpointSource {
+	if d.cfg.EndpointSource != nil {
+		return d.cfg.EndpointSource
+	}
+	operatorID, _ := d.identity()
+	return &apiEndpointSource{
+		client:     d.apiClient,
+		operatorID: operatorID,
+		merge:      d.cfg.EndpointMergePolicy,
+		dedupByID:  d.cfg.DedupByID,
+	}
+}
+
+// cachedEndpoints returns a copy of the last discovery result and when it happened.
+func (d *discoveryDialer) cachedEndpoints() ([]Endpoint, time.Time) {
+	d.endpointsMu.RLock()
+	defer d.endpointsMu.RUnlock()
+	return append([]Endpoint(nil), d.endpoints...), d.lastDiscovery
+}
+
+func (d *discoveryDialer) setEndpoints(endpoints []Endpoint, at time.Time) {
+	d.endpointsMu.Lock()
+	defer d.endpointsMu.Unlock()
+	d.endpoints = endpoints
+	d.lastDiscovery = at
+}
+
+// lookupPort returns the port of a discovered endpoint matching scheme and hostname.
+func (d *discoveryDialer) lookupPort(scheme, hostname string) (int, bool) {
+	d.endpointsMu.RLock()
+	defer d.endpointsMu.RUnlock()
+
+	for _, ep := range d.endpoints {
+		if ep.URL.Scheme != scheme || !strings.EqualFold(ep.Hostname(), hostname) {
+			continue
+		}
+		if port, err := strconv.Atoi(ep.URL.Port()); err == nil {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// lookupEndpoint returns the discovered endpoint serving hostname and port.
+func (d *discoveryDialer) lookupEndpoint(hostname string, port int) (Endpoint, bool) {
+	d.endpointsMu.RLock()
+	defer d.endpointsMu.RUnlock()
+
+	for _, ep := range d.endpoints {
+		if !strings.EqualFold(ep.Hostname(), hostname) {
+			continue
+		}
+		if p, ok := ep.port(); ok && p == port {
+			return ep, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// resolveEndpoint returns the discovered endpoint serving hostname and port,
+// or an Endpoint with only the hostname and port set in URL.
+func (d *discoveryDialer) resolveEndpoint(hostname string, port int) Endpoint {
+	if ep, ok := d.lookupEndpoint(hostname, port); ok {
+		return ep
+	}
+	return Endpoint{URL: &url.URL{Host: net.JoinHostPort(hostname, strconv.Itoa(port))}}
+}
+
+// authorizeDial passes the endpoint for hostname and port to DialAuthorizer.
+func (d *discoveryDialer) authorizeDial(ctx context.Context, hostname string, port int) error {
+	ep := d.resolveEndpoint(hostname, port)
+	if err := d.cfg.DialAuthorizer(ctx, ep); err != nil {
+		return &AuthorizationDeniedError{Endpoint: ep, Err: err}
+	}
+	return nil
+}
 
+// binder dials the ngrok ingress and upgrades connections to bound endpoints.
+// It holds the dial state shared by dialer and discoveryDialer.
+type binder struct {
+	// certMu guards tlsConfig, which RotateKey replaces, and rootCAs, which
+	// TrustOperatorChain extends.
+	certMu    sync.RWMutex
+	tlsConfig *tls.Config
+	rootCAs   *x509.CertPool
 
-// dialNgrok is the shared dial implementation.
-func dialNgrok(ctx context.Context, ingressDialer ContextDialer, ingressEndpoint string, tlsConfig *tls.Config, rootCAs *x509.CertPool, hostname string, port int, logger logr.Logger) (net.Conn, error) {
-	ingressHost, _, _ := net.SplitHostPort(ingressEndpoint)
+	ingressEndpoint         string
+	ingressDialer           ContextDialer
+	onIngressCertChange     func(ingressEndpoint string, err error)
+	onProtoMismatch         func(address, requested, bound string)
+	logger                  logr.Logger
+	hostnameRewrite         func(hostname string) string
+	endpointAliases         map[string]string
+	allowHosts              []string
+	denyHosts               []string
+	dialTimeouts            map[string]time.Duration
+	readTimeout             time.Duration
+	writeTimeout            time.Duration
+	keepAlive               time.Duration
+	detectImmediateClose    bool
+	renewBefore             time.Duration
+	captureFailedHandshakes bool
+
+	// portLookup, if set, supplies the port for tcp:// addresses
+	// dialed without one.
+	portLookup func(scheme, hostname string) (int, bool)
+
+	// prepare, if set, runs before each dial, e.g. to provision lazily.
+	prepare func(ctx context.Context) error
+
+	// authorize, if set, runs before each binding upgrade is dialed and
+	// aborts it with its error.
+	authorize func(ctx context.Context, hostname string, port int) error
+
+	// requestHook, if set, can modify each binding request before it is sent.
+	requestHook func(req *ConnRequest)
+
+	// quarantine, if set, rejects dials to endpoints whose binding upgrades
+	// keep failing. Probes are let through so they can release them.
+	quarantine *quarantine
+}
+
+// IngressCAPool returns a copy of the CA pool used to verify the ngrok ingress,
+// or nil if verification is disabled because no RootCAs were configured and
+// no operator chain was trusted.
+func (b *binder) IngressCAPool() *x509.CertPool {
+	rootCAs := b.ingressRoots()
+	if rootCAs == nil {
+		return nil
+	}
+	return rootCAs.Clone()
+}
+
+// ingressRoots returns the pool used to verify the ingress, or nil if
+// verification is disabled. It must not be modified.
+func (b *binder) ingressRoots() *x509.CertPool {
+	b.certMu.RLock()
+	defer b.certMu.RUnlock()
+	return b.rootCAs
+}
+
+// trustChain adds the CA certificates following the leaf in cert's chain to
+// the pool used to verify the ingress, enabling verification if it was off.
+// It must be called before setClientCert for the pool to take effect.
+func (b *binder) trustChain(cert tls.Certificate) error {
+	if len(cert.Certificate) < 2 {
+		return nil
+	}
+
+	b.certMu.Lock()
+	defer b.certMu.Unlock()
+
+	pool := x509.NewCertPool()
+	if b.rootCAs != nil {
+		pool = b.rootCAs.Clone()
+	}
+	for _, der := range cert.Certificate[1:] {
+		ca, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid certificate in operator chain: %w", err)
+		}
+		pool.AddCert(ca)
+	}
+	b.rootCAs = pool
+
+	return nil
+}
+
+// Listen always returns ErrListenNotSupported. It exists so that code treating
+// a dialer as a server fails with an explanation rather than a missing method.
+func (b *binder) Listen(ctx context.Context, hostname string) (net.Listener, error) {
+	return nil, fmt.Errorf("listen %s: %w", hostname, ErrListenNotSupported)
+}
+
+// clientTLSConfig returns the TLS config presenting the current client certificate.
+// It must not be modified.
+func (b *binder) clientTLSConfig() *tls.Config {
+	b.certMu.RLock()
+	defer b.certMu.RUnlock()
+	return b.tlsConfig
+}
+
+// ResetSessionCache discards cached ingress TLS sessions, so subsequent dials
+// perform a full handshake, e.g. after the ingress certificate changed.
+// Replacing the client certificate resets the cache as well.
+func (b *binder) ResetSessionCache() {
+	b.certMu.Lock()
+	defer b.certMu.Unlock()
+	if b.tlsConfig == nil {
+		return
+	}
+	tlsConfig := b.tlsConfig.Clone()
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(sessionCacheSize)
+	b.tlsConfig = tlsConfig
+}
+
+// setClientCert replaces the client certificate used by subsequent dials,
+// with a fresh session cache.
+func (b *binder) setClientCert(cert tls.Certificate) {
+	b.certMu.Lock()
+	defer b.certMu.Unlock()
+	b.tlsConfig = buildTLSConfig(cert, b.rootCAs)
+}
+
+// RenewAfter returns how long until the client certificate is due for renewal,
+// RenewBefore ahead of its expiry, so callers can schedule RotateKey or
+// re-provisioning themselves. It returns 0 if renewal is already due.
+func (b *binder) RenewAfter() (time.Duration, error) {
+	return b.renewAfter(time.Now())
+}
+
+func (b *binder) renewAfter(now time.Time) (time.Duration, error) {
+	tlsConfig := b.clientTLSConfig()
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 || len(tlsConfig.Certificates[0].Certificate) == 0 {
+		return 0, fmt.Errorf("no client certificate")
+	}
+	leaf, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+
+	renewBefore := b.renewBefore
+	if renewBefore == 0 {
+		renewBefore = leaf.NotAfter.Sub(leaf.NotBefore) / 3
+	}
+
+	if until := leaf.NotAfter.Add(-renewBefore).Sub(now); until > 0 {
+		return until, nil
+	}
+	return 0, nil
+}
+
+// exportCert encodes the client certificate chain and private key as PEM.
+func (b *binder) exportCert() (certPEM, keyPEM []byte, err error) {
+	tlsConfig := b.clientTLSConfig()
+	if len(tlsConfig.Certificates) == 0 {
+		return nil, nil, fmt.Errorf("no client certificate")
+	}
+	cert := tlsConfig.Certificates[0]
+
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	switch key := cert.PrivateKey.(type) {
+	case *ecdsa.PrivateKey:
+		// Same encoding the provisioner stores
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// writeSummary writes the dial settings shared by both dialers to sb.
+func (b *binder) writeSummary(sb *strings.Builder) {
+	fmt.Fprintf(sb, "ingress_endpoint: %s\n", b.ingressEndpoint)
+	fmt.Fprintf(sb, "ingress_tls_verify: %t\n", b.ingressRoots() != nil)
+
+	if tlsConfig := b.clientTLSConfig(); tlsConfig != nil && len(tlsConfig.Certificates) > 0 && len(tlsConfig.Certificates[0].Certificate) > 0 {
+		if leaf, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0]); err == nil {
+			fmt.Fprintf(sb, "cert_subject: %s\n", leaf.Subject)
+			fmt.Fprintf(sb, "cert_not_after: %s\n", leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+
+	fmt.Fprintf(sb, "allow_hosts: %v\n", b.allowHosts)
+	fmt.Fprintf(sb, "deny_hosts: %v\n", b.denyHosts)
+	fmt.Fprintf(sb, "endpoint_aliases: %d\n", len(b.endpointAliases))
+	fmt.Fprintf(sb, "hostname_rewrite: %t\n", b.hostnameRewrite != nil)
+	fmt.Fprintf(sb, "dial_timeouts: %v\n", b.dialTimeouts)
+	fmt.Fprintf(sb, "conn_read_timeout: %s\n", b.readTimeout)
+	fmt.Fprintf(sb, "conn_write_timeout: %s\n", b.writeTimeout)
+	fmt.Fprintf(sb, "keep_alive: %s\n", b.keepAlive)
+	fmt.Fprintf(sb, "detect_immediate_close: %t\n", b.detectImmediateClose)
+}
+
+// DialInfo describes a connection established by DialContextInfo.
+type DialInfo struct {
+	EndpointID  string
+	Proto       string
+	IngressAddr string
+
+	// Duration covers the ingress connect, TLS handshake and binding upgrade.
+	Duration time.Duration
+
+	// DidResume reports whether the TLS handshake with the ingress resumed a
+	// cached session rather than performing a full handshake.
+	DidResume bool
+}
+
+// dialAddress parses the address and dials it via ngrok.
+func (b *binder) dialAddress(ctx context.Context, address string) (net.Conn, DialInfo, error) {
+	hostname, port, err := b.resolveAddress(address)
+	if err != nil {
+		return nil, DialInfo{}, err
+	}
+
+	if b.quarantine != nil {
+		if err := b.quarantine.check(hostname); err != nil {
+			return nil, DialInfo{}, err
+		}
+	}
+
+	if b.logger.Enabled() {
+		b.logger.V(1).Info("Dialing via ngrok", "hostname", hostname, "port", port)
+	}
+
+	start := time.Now()
+	conn, bound, err := b.dial(ctx, hostname, port)
+	if err != nil {
+		return nil, DialInfo{}, err
+	}
+
+	b.checkProto(address, bound.proto)
+
+	return conn, DialInfo{
+		EndpointID:  bound.endpointID,
+		Proto:       bound.proto,
+		IngressAddr: b.ingressEndpoint,
+		Duration:    time.Since(start),
+		DidResume:   bound.didResume,
+	}, nil
+}
+
+// ProbeResult describes a successful binding upgrade performed by Probe.
+type ProbeResult struct {
+	Hostname   string
+	Port       int
+	EndpointID string
+	Proto      string
+
+	// Latency covers the ingress connect, TLS handshake and binding upgrade.
+	Latency time.Duration
+}
+
+// Probe checks that address is reachable by dialing it and performing the
+// binding upgrade, then closes the connection without sending any data.
+func (b *binder) Probe(ctx context.Context, address string) (*ProbeResult, error) {
+	hostname, port, err := b.resolveAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	conn, bound, err := b.dial(ctx, hostname, port)
+	if err != nil {
+		return nil, err
+	}
+	latency := time.Since(start)
+	conn.Close()
+
+	return &ProbeResult{
+		Hostname:   hostname,
+		Port:       port,
+		EndpointID: bound.endpointID,
+		Proto:      bound.proto,
+		Latency:    latency,
+	}, nil
+}
+
+// ProbeTCP checks that the ingress is reachable by connecting and completing
+// the TLS handshake, then closes the connection without a binding upgrade.
+// It returns how long that took, isolating ingress reachability from
+// endpoint and backend availability.
+func (b *binder) ProbeTCP(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	conn, err := b.connectIngress(ctx)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	conn.Close()
+
+	return latency, nil
+}
+
+// checkProto reports a dialed URL whose scheme differs from the bound proto.
+// Addresses without a scheme request no particular proto.
+func (b *binder) checkProto(address, proto string) {
+	if !strings.Contains(address, "://") || proto == "" {
+		return
+	}
+	u, err := url.Parse(address)
+	if err != nil || strings.EqualFold(u.Scheme, proto) {
+		return
+	}
+
+	if b.logger.Enabled() {
+		b.logger.Info("Endpoint bound with a different proto than requested", "address", address, "requested", u.Scheme, "bound", proto)
+	}
+	if b.onProtoMismatch != nil {
+		b.onProtoMismatch(address, u.Scheme, proto)
+	}
+}
+
+// reconnectTimeout bounds a ReconnectingConn re-dial to an endpoint without
+// a DialTimeouts entry.
+const reconnectTimeout = 30 * time.Second
+
+// DialReconnecting dials address like DialContext and returns a connection
+// that re-dials it after the connection drops. See ReconnectingConn.
+//
+// ctx only bounds the initial dial. Each re-dial is bounded by the
+// endpoint's DialTimeouts entry, or reconnectTimeout if it has none.
+func (b *binder) DialReconnecting(ctx context.Context, address string) (*ReconnectingConn, error) {
+	conn, _, err := b.dialAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	connCtx, cancel := context.WithCancel(context.Background())
+	return &ReconnectingConn{
+		conn:   conn,
+		ctx:    connCtx,
+		cancel: cancel,
+		dial: func(ctx context.Context) (net.Conn, error) {
+			// binder.dial applies a DialTimeouts entry itself
+			hostname, _, err := b.resolveAddress(address)
+			if _, ok := b.dialTimeouts[hostname]; !ok || err != nil {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, reconnectTimeout)
+				defer cancel()
+			}
+			conn, _, err := b.dialAddress(ctx, address)
+			return conn, err
+		},
+	}, nil
+}
+
+// resolveAddress applies aliases, hostname rewriting, host policy and port
+// lookup to address, returning the endpoint hostname and port to bind.
+func (b *binder) resolveAddress(address string) (string, int, error) {
+	hostname, port, explicit, err := parseAddress(address)
+	var scheme string
+	if !explicit && b.portLookup != nil && strings.Contains(address, "://") &&
+		(err == nil || errors.Is(err, errPortRequired)) {
+		// Resolve the port from discovery once the hostname is final
+		u, _ := url.Parse(address)
+		scheme, hostname, err = u.Scheme, u.Hostname(), nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address %q: %w", address, err)
+	}
+
+	if canonical, ok := b.endpointAliases[hostname]; ok {
+		if b.logger.Enabled() {
+			b.logger.V(1).Info("Resolved endpoint alias", "alias", hostname, "hostname", canonical)
+		}
+		hostname = canonical
+	} else if b.hostnameRewrite != nil {
+		rewritten := b.hostnameRewrite(hostname)
+		if b.logger.Enabled() && rewritten != hostname {
+			b.logger.V(1).Info("Rewrote hostname", "from", hostname, "to", rewritten)
+		}
+		hostname = rewritten
+	}
+
+	if !b.hostAllowed(hostname) {
+		return "", 0, &HostDeniedError{Hostname: hostname}
+	}
+
+	if scheme != "" {
+		p, ok := b.portLookup(scheme, hostname)
+		switch {
+		case ok:
+			port = p
+		case port == 0:
+			return "", 0, fmt.Errorf("invalid address %q: %s %w and %s is not a discovered endpoint", address, scheme, errPortRequired, hostname)
+		}
+	}
+
+	return hostname, port, nil
+}
+
+// hostAllowed reports whether hostname passes the deny and allow lists.
+func (b *binder) hostAllowed(hostname string) bool {
+	for _, pattern := range b.denyHosts {
+		if ok, _ := path.Match(pattern, hostname); ok {
+			return false
+		}
+	}
+
+	if len(b.allowHosts) == 0 {
+		return true
+	}
+
+	for _, pattern := range b.allowHosts {
+		if ok, _ := path.Match(pattern, hostname); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// binding is the ingress response to a successful binding upgrade.
+type binding struct {
+	endpointID string
+	proto      string
+	didResume  bool
+}
+
+// dial connects to the ingress and upgrades the connection to hostname:port.
+func (b *binder) dial(ctx context.Context, hostname string, port int) (net.Conn, binding, error) {
+	if timeout, ok := b.dialTimeouts[hostname]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if b.authorize != nil {
+		if err := b.authorize(ctx, hostname, port); err != nil {
+			return nil, binding{}, err
+		}
+	}
+
+	tlsConn, err := b.connectIngress(ctx)
+	if err != nil {
+		return nil, binding{}, err
+	}
+
+	// The binding upgrade is plain I/O, so bound it by the context deadline
+	// and interrupt it if the context is canceled
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		tlsConn.SetDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+
+	req := ConnRequest{Host: hostname, Port: port}
+	if b.requestHook != nil {
+		b.requestHook(&req)
+	}
+
+	endpointID, proto, err := upgradeToBinding(tlsConn, req, b.captureFailedHandshakes)
+	if !stop() {
+		// The deadline may be expired even if the upgrade succeeded
+		<-interrupted
+		err = ctx.Err()
+	}
+	if b.quarantine != nil && ctx.Err() == nil {
+		b.quarantine.record(hostname, err == nil)
+	}
+	if err != nil {
+		tlsConn.Close()
+		return nil, binding{}, fmt.Errorf("upgrade %s:%d: %w", hostname, port, err)
+	}
+
+	tlsConn.SetDeadline(time.Time{})
+
+	if b.logger.Enabled() {
+		b.logger.V(1).Info("Connection upgraded", "endpointID", endpointID, "proto", proto)
+	}
+
+	bound := binding{endpointID: endpointID, proto: proto, didResume: tlsConn.ConnectionState().DidResume}
+
+	var conn net.Conn = tlsConn
+	if b.detectImmediateClose {
+		conn = &immediateCloseConn{Conn: conn, hostname: hostname, port: port, endpointID: endpointID, upgradedAt: time.Now()}
+	}
+	if b.readTimeout > 0 || b.writeTimeout > 0 {
+		conn = &timeoutConn{Conn: conn, readTimeout: b.readTimeout, writeTimeout: b.writeTimeout}
+	}
+
+	return conn, bound, nil
+}
+
+// connectIngress connects to the ingress and completes the mTLS handshake.
+func (b *binder) connectIngress(ctx context.Context) (*tls.Conn, error) {
+	ingressHost, _, _ := net.SplitHostPort(b.ingressEndpoint)
 	if ingressHost == "" {
-		ingressHost = ingressEndpoint
+		ingressHost = b.ingressEndpoint
+	}
+
+	if b.prepare != nil {
+		if err := b.prepare(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	tlsCfg := tlsConfig.Clone()
+	tlsCfg := b.clientTLSConfig().Clone()
 	tlsCfg.ServerName = ingressHost
 
-	if rootCAs == nil {
+	if b.ingressRoots() == nil {
 		tlsCfg.InsecureSkipVerify = true
 	}
 
-	tcpConn, err := ingressDialer.DialContext(ctx, "tcp", ingressEndpoint)
+	tcpConn, err := b.ingressDialer.DialContext(ctx, "tcp", b.ingressEndpoint)
 	if err != nil {
-		return nil, fmt.Errorf("dial %s: %w", ingressEndpoint, err)
+		return nil, fmt.Errorf("dial %s: %w", b.ingressEndpoint, err)
+	}
+
+	if b.keepAlive > 0 {
+		if err := setKeepAlive(tcpConn, b.keepAlive); err != nil && b.logger.Enabled() {
+			b.logger.V(1).Info("Failed to enable TCP keep-alive", "error", err.Error())
+		}
 	}
 
 	tlsConn := tls.Client(tcpConn, tlsCfg)
 	if err := tlsConn.HandshakeContext(ctx); err != nil {
 		tcpConn.Close()
-		return nil, fmt.Errorf("TLS handshake %s: %w", ingressEndpoint, err)
+		if isCertVerificationFailure(err) && b.onIngressCertChange != nil {
+			b.onIngressCertChange(b.ingressEndpoint, err)
+		}
+		if isNotTLS(err) {
+			err = fmt.Errorf("%w: %w", ErrIngressNotTLS, err)
+		}
+		if isTLSHandshakeFailure(err) {
+			return nil, &TLSHandshakeError{IngressEndpoint: b.ingressEndpoint, Err: err}
+		}
+		return nil, fmt.Errorf("TLS handshake %s: %w", b.ingressEndpoint, err)
 	}
 
-	endpointID, proto, err := upgradeToBinding(tlsConn, hostname, port)
-	if err != nil {
-		tlsConn.Close()
-		return nil, fmt.Errorf("upgrade %s:%d: %w", hostname, port, err)
+	return tlsConn, nil
+}
+
+// copyDurations returns a copy of m so later changes by the caller are not observed.
+func copyDurations(m map[string]time.Duration) map[string]time.Duration {
+	if m == nil {
+		return nil
 	}
+	c := make(map[string]time.Duration, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
 
-	if logger.Enabled() {
-		logger.V(1).Info("Connection upgraded", "endpointID", endpointID, "proto", proto)
+// copyStrings returns a copy of m so later changes by the caller are not observed.
+func copyStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
 	}
+	return c
+}
 
-	return tlsConn, nil
+// setKeepAlive enables TCP keep-alive probes on conn if it supports them.
+func setKeepAlive(conn net.Conn, period time.Duration) error {
+	kc, ok := conn.(interface {
+		SetKeepAlive(bool) error
+		SetKeepAlivePeriod(time.Duration) error
+	})
+	if !ok {
+		return fmt.Errorf("%T does not support keep-alive", conn)
+	}
+	if err := kc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return kc.SetKeepAlivePeriod(period)
 }
 
+// redact hides a secret while still showing whether it was set.
+func redact(secret string) string {
+	if secret == "" {
+		return "(unset)"
+	}
+	return "[redacted]"
+}
+
+// sessionCacheSize is the number of ingress TLS sessions kept for resumption.
+const sessionCacheSize = 128
+
 // buildTLSConfig creates a TLS config with the given certificate and CA pool.
 func buildTLSConfig(cert tls.Certificate, rootCAs *x509.CertPool) *tls.Config {
 	if rootCAs == nil {
@@ -218,6 +1401,6 @@ func buildTLSConfig(cert tls.Certificate, rootCAs *x509.CertPool) *tls.Config {
 	return &tls.Config{
 		Certificates:       []tls.Certificate{cert},
 		RootCAs:            rootCAs,
-		ClientSessionCache: tls.NewLRUClientSessionCache(128),
+		ClientSessionCache: tls.NewLRUClientSessionCache(sessionCacheSize),
 	}
 }
@@ -1,6 +1,7 @@
 package ngrokd
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -8,8 +9,18 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
+	"net"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -19,21 +30,26 @@ func TestParseAddress(t *testing.T) {
 		input    string
 		hostname string
 		port     int
+		explicit bool
 		wantErr  bool
 	}{
-		{"app.example", "app.example", 80, false},
-		{"app.example:8080", "app.example", 8080, false},
-		{"http://app.example", "app.example", 80, false},
-		{"http://app.example:9000", "app.example", 9000, false},
-		{"tcp://app.example:443", "app.example", 443, false},
-		{"tcp://app.example", "", 0, true},
-		{"tls://app.example:443", "app.example", 443, false},
-		{"tls://app.example", "", 0, true},
+		{"app.example", "app.example", 80, false, false},
+		{"app.example:8080", "app.example", 8080, true, false},
+		{"app.example:80", "app.example", 80, true, false},
+		{"http://app.example", "app.example", 80, false, false},
+		{"http://app.example:80", "app.example", 80, true, false},
+		{"http://app.example:9000", "app.example", 9000, true, false},
+		{"tcp://app.example:443", "app.example", 443, true, false},
+		{"tcp://app.example", "", 0, false, true},
+		{"tls://app.example:443", "app.example", 443, true, false},
+		{"tls://app.example", "app.example", 443, false, false},
+		{"https://app.example", "app.example", 443, false, false},
+		{"https://app.example:8443", "app.example", 8443, true, false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			hostname, port, err := parseAddress(tt.input)
+			hostname, port, explicit, err := parseAddress(tt.input)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseAddress(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
 				return
@@ -44,10 +60,52 @@ func TestParseAddress(t *testing.T) {
 			if port != tt.port {
 				t.Errorf("parseAddress(%q) port = %v, want %v", tt.input, port, tt.port)
 			}
+			if explicit != tt.explicit {
+				t.Errorf("parseAddress(%q) explicit = %v, want %v", tt.input, explicit, tt.explicit)
+			}
+		})
+	}
+}
+
+func TestDefaultPortConsistency(t *testing.T) {
+	for _, scheme := range []string{"http", "https", "tls", "tcp"} {
+		t.Run(scheme, func(t *testing.T) {
+			_, addrPort, _, addrErr := parseAddress(scheme + "://app.example")
+			epPort, epOK := Endpoint{URL: mustParseURL(scheme + "://app.example")}.port()
+
+			if (addrErr == nil) != epOK || addrPort != epPort {
+				t.Errorf("parseAddress gives %d (err %v), endpoint gives %d (ok %t)", addrPort, addrErr, epPort, epOK)
+			}
 		})
 	}
 }
 
+func TestEndpointDialURL(t *testing.T) {
+	tests := []struct {
+		proto string
+		url   string
+		want  string
+	}{
+		{proto: "http", url: "http://app.example", want: "http://app.example"},
+		{proto: "http", url: "http://app.example:80/", want: "http://app.example"},
+		{proto: "http", url: "http://app.example:8080", want: "http://app.example:8080"},
+		{proto: "https", url: "https://app.example:443", want: "https://app.example"},
+		{proto: "tls", url: "tls://app.example", want: "tls://app.example:443"},
+		{proto: "tls", url: "https://app.example:8443", want: "tls://app.example:8443"},
+		{proto: "tcp", url: "tcp://db.example:5432", want: "tcp://db.example:5432"},
+		{proto: "TCP", url: "tcp://[::1]:5432", want: "tcp://[::1]:5432"},
+		{proto: "tcp", url: "tcp://db.example", want: "tcp://db.example"},
+		{proto: "", url: "TLS://app.example", want: "tls://app.example:443"},
+	}
+
+	for _, tt := range tests {
+		ep := Endpoint{Proto: tt.proto, URL: mustParseURL(tt.url)}
+		if got := ep.DialURL(); got != tt.want {
+			t.Errorf("DialURL() for proto %q and URL %s = %s, want %s", tt.proto, tt.url, got, tt.want)
+		}
+	}
+}
+
 func TestDiscoveryDialerRequiresAPIKey(t *testing.T) {
 	ctx := context.Background()
 	_, err := DiscoveryDialer(ctx, Config{})
@@ -65,6 +123,33 @@ func TestDialerLoadsFromStore(t *testing.T) {
 	}
 }
 
+func TestDialerCertDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	_, err := Dialer(DirectConfig{CertDir: dir})
+	if err == nil || !strings.Contains(err.Error(), dir) {
+		t.Fatalf("expected an error naming the empty directory, got %v", err)
+	}
+
+	cert := generateTestCert(t)
+	keyPEM, certPEM := encodeTestCert(t, cert)
+	if err := NewFileStore(dir).Save(ctx, keyPEM, certPEM, "k8sop_saved"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	d, err := Dialer(DirectConfig{CertDir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.operatorID != "k8sop_saved" {
+		t.Errorf("expected operator from the store, got %q", d.operatorID)
+	}
+	if !bytes.Equal(d.tlsConfig.Certificates[0].Certificate[0], cert.Certificate[0]) {
+		t.Error("expected the certificate saved in CertDir")
+	}
+}
+
 func TestDialer(t *testing.T) {
 	cert := generateTestCert(t)
 
@@ -80,36 +165,1316 @@ func TestDialer(t *testing.T) {
 	}
 }
 
-func mustParseURL(s string) *url.URL {
-	u, err := url.Parse(s)
+func TestDialerHostnameRewrite(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		HostnameRewrite: func(hostname string) string {
+			if hostname == "app.internal" {
+				return "app.namespace"
+			}
+			return hostname
+		},
+	})
 	if err != nil {
-		panic(err)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := d.Dial("tcp", "app.internal:8080")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	conn, err = d.Dial("tcp", "other.namespace:8080")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	reqs := ingress.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 binding requests, got %d", len(reqs))
+	}
+	if reqs[0].Host != "app.namespace" || reqs[0].Port != 8080 {
+		t.Errorf("expected rewritten request app.namespace:8080, got %s:%d", reqs[0].Host, reqs[0].Port)
+	}
+	if reqs[1].Host != "other.namespace" {
+		t.Errorf("expected unmatched hostname to pass through, got %s", reqs[1].Host)
 	}
-	return u
 }
 
-func generateTestCert(t *testing.T) tls.Certificate {
-	t.Helper()
+func TestDialerEndpointAliases(t *testing.T) {
+	ingress := newTestIngress(t, nil)
 
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointAliases: map[string]string{"payments": "payments.prod"},
+		HostnameRewrite: func(hostname string) string {
+			return hostname + ".rewritten"
+		},
+	})
 	if err != nil {
-		t.Fatalf("failed to generate key: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject:      pkix.Name{CommonName: "test"},
-		NotBefore:    time.Now(),
-		NotAfter:     time.Now().Add(time.Hour),
+	for _, address := range []string{"payments:5432", "orders:5432"} {
+		conn, err := d.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", address, err)
+		}
+		conn.Close()
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	reqs := ingress.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 binding requests, got %d", len(reqs))
+	}
+	if reqs[0].Host != "payments.prod" || reqs[0].Port != 5432 {
+		t.Errorf("expected alias to resolve to payments.prod:5432, got %s:%d", reqs[0].Host, reqs[0].Port)
+	}
+	if reqs[1].Host != "orders.rewritten" {
+		t.Errorf("expected non-alias to use HostnameRewrite, got %s", reqs[1].Host)
+	}
+}
+
+func TestDialerHostPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		host    string
+		allowed bool
+	}{
+		{"allow-only match", []string{"*.prod"}, nil, "payments.prod", true},
+		{"allow-only miss", []string{"*.prod"}, nil, "payments.staging", false},
+		{"deny-only match", nil, []string{"admin.*"}, "admin.prod", false},
+		{"deny-only miss", nil, []string{"admin.*"}, "payments.prod", true},
+		{"overlap deny wins", []string{"*.prod"}, []string{"admin.prod"}, "admin.prod", false},
+		{"overlap allowed", []string{"*.prod"}, []string{"admin.prod"}, "payments.prod", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ingress := newTestIngress(t, nil)
+
+			d, err := Dialer(DirectConfig{
+				Cert:            generateTestCert(t),
+				IngressEndpoint: ingress.addr,
+				AllowHosts:      tt.allow,
+				DenyHosts:       tt.deny,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			conn, err := d.Dial("tcp", tt.host+":80")
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("expected dial to be allowed, got %v", err)
+				}
+				conn.Close()
+				return
+			}
+
+			var denied *HostDeniedError
+			if !errors.As(err, &denied) {
+				t.Fatalf("expected HostDeniedError, got %v", err)
+			}
+			if denied.Hostname != tt.host {
+				t.Errorf("expected denied hostname %s, got %s", tt.host, denied.Hostname)
+			}
+			if reqs := ingress.Requests(); len(reqs) != 0 {
+				t.Errorf("expected denied dial to never reach the ingress, got %d requests", len(reqs))
+			}
+		})
+	}
+}
+
+func TestDialerRejectsInvalidHostPattern(t *testing.T) {
+	_, err := Dialer(DirectConfig{
+		Cert:       generateTestCert(t),
+		AllowHosts: []string{"[invalid"},
+	})
+	if err == nil {
+		t.Fatal("expected error for invalid host pattern")
+	}
+}
+
+func TestDialerPerHostTimeout(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+	recorder := &deadlineRecorder{}
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		IngressDialer:   recorder,
+		DialTimeouts:    map[string]time.Duration{"batch.example": 5 * time.Minute},
+	})
 	if err != nil {
-		t.Fatalf("failed to create cert: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	return tls.Certificate{
-		Certificate: [][]byte{certDER},
-		PrivateKey:  key,
+	for _, address := range []string{"batch.example:80", "web.example:80"} {
+		conn, err := d.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", address, err)
+		}
+		conn.Close()
+	}
+
+	deadlines := recorder.Deadlines()
+	if len(deadlines) != 2 {
+		t.Fatalf("expected 2 dials, got %d", len(deadlines))
+	}
+	if remaining := time.Until(deadlines[0]); deadlines[0].IsZero() || remaining > 5*time.Minute || remaining < 4*time.Minute {
+		t.Errorf("expected ~5m deadline for overridden host, got %v", deadlines[0])
+	}
+	if !deadlines[1].IsZero() {
+		t.Errorf("expected no deadline for host without override, got %v", deadlines[1])
+	}
+}
+
+func TestDialerCanceledDuringUpgrade(t *testing.T) {
+	var cancelDial atomic.Pointer[context.CancelFunc]
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		// Cancel while the client waits for a response it will still receive
+		(*cancelDial.Load())()
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "tcp"}
+	})
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelDial.Store(&cancel)
+		conn, err := d.DialContext(ctx, "tcp", "db.example:5432")
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			if conn != nil {
+				conn.Close()
+			}
+			t.Fatalf("dial %d: expected context.Canceled, got %v", i, err)
+		}
+	}
+}
+
+// deadlineRecorder is an IngressDialer that records each dial's context deadline.
+type deadlineRecorder struct {
+	mu        sync.Mutex
+	deadlines []time.Time
+}
+
+func (r *deadlineRecorder) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	deadline, _ := ctx.Deadline()
+	r.mu.Lock()
+	r.deadlines = append(r.deadlines, deadline)
+	r.mu.Unlock()
+
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func (r *deadlineRecorder) Deadlines() []time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]time.Time(nil), r.deadlines...)
+}
+
+func TestDialerTLSHandshakeError(t *testing.T) {
+	expired := generateTestCertValidity(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	expiredLeaf, err := x509.ParseCertificate(expired.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse cert: %v", err)
+	}
+	expiredRoots := x509.NewCertPool()
+	expiredRoots.AddCert(expiredLeaf)
+
+	tests := []struct {
+		name    string
+		ingress string
+		rootCAs *x509.CertPool
+		check   func(error) bool
+	}{
+		{
+			name:    "not tls",
+			ingress: newPlaintextServer(t),
+			check: func(err error) bool {
+				var target tls.RecordHeaderError
+				return errors.As(err, &target) && errors.Is(err, ErrIngressNotTLS)
+			},
+		},
+		{
+			name:    "unknown authority",
+			ingress: newTestIngress(t, nil).addr,
+			rootCAs: x509.NewCertPool(),
+			check: func(err error) bool {
+				var target x509.UnknownAuthorityError
+				return errors.As(err, &target)
+			},
+		},
+		{
+			name:    "expired",
+			ingress: newTestIngressWithCert(t, expired, nil).addr,
+			rootCAs: expiredRoots,
+			check: func(err error) bool {
+				var target x509.CertificateInvalidError
+				return errors.As(err, &target) && target.Reason == x509.Expired
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Dialer(DirectConfig{
+				Cert:            generateTestCert(t),
+				IngressEndpoint: tt.ingress,
+				RootCAs:         tt.rootCAs,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = d.Dial("tcp", "app.example:80")
+			var handshakeErr *TLSHandshakeError
+			if !errors.As(err, &handshakeErr) {
+				t.Fatalf("expected TLSHandshakeError, got %v", err)
+			}
+			if handshakeErr.IngressEndpoint != tt.ingress {
+				t.Errorf("expected ingress %s, got %s", tt.ingress, handshakeErr.IngressEndpoint)
+			}
+			if !tt.check(err) {
+				t.Errorf("unexpected underlying error: %v", err)
+			}
+			if notTLS := errors.Is(err, ErrIngressNotTLS); notTLS != (tt.name == "not tls") {
+				t.Errorf("expected ErrIngressNotTLS only for a plaintext ingress, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCaptureFailedHandshakes(t *testing.T) {
+	// Wire type 7 is not valid protobuf
+	garbled := []byte{0x0f, 0xde, 0xad}
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		if req.Host == "garbled.example" {
+			return testBindingResponse{Raw: garbled}
+		}
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http"}
+	})
+
+	newDialer := func(capture bool) *dialer {
+		t.Helper()
+		d, err := Dialer(DirectConfig{
+			Cert:                    generateTestCert(t),
+			IngressEndpoint:         ingress.addr,
+			CaptureFailedHandshakes: capture,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return d
+	}
+
+	_, err := newDialer(true).Dial("tcp", "garbled.example:80")
+	var protoErr *BindingProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("expected BindingProtocolError, got %v", err)
+	}
+	wantRequest := hex.EncodeToString(marshalBindingRequest(ConnRequest{Host: "garbled.example", Port: 80}))
+	if protoErr.Request != wantRequest {
+		t.Errorf("expected request frame %s, got %s", wantRequest, protoErr.Request)
+	}
+	if want := "0300" + hex.EncodeToString(garbled); protoErr.Response != want {
+		t.Errorf("expected response frame %s, got %s", want, protoErr.Response)
+	}
+
+	// Without capture the error carries no frames
+	_, err = newDialer(false).Dial("tcp", "garbled.example:80")
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("expected BindingProtocolError, got %v", err)
+	}
+	if protoErr.Request != "" || protoErr.Response != "" {
+		t.Errorf("expected no captured frames, got %+v", protoErr)
+	}
+
+	conn, err := newDialer(true).Dial("tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerOnIngressCertChange(t *testing.T) {
+	var calls []string
+	onChange := func(ingressEndpoint string, err error) {
+		calls = append(calls, ingressEndpoint)
+	}
+
+	ingress := newTestIngress(t, nil)
+	d, err := Dialer(DirectConfig{
+		Cert:                generateTestCert(t),
+		IngressEndpoint:     ingress.addr,
+		RootCAs:             x509.NewCertPool(),
+		OnIngressCertChange: onChange,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var handshakeErr *TLSHandshakeError
+	if _, err := d.Dial("tcp", "app.example:80"); !errors.As(err, &handshakeErr) {
+		t.Fatalf("expected TLSHandshakeError, got %v", err)
+	}
+	if len(calls) != 1 || calls[0] != ingress.addr {
+		t.Fatalf("expected one OnIngressCertChange call for %s, got %v", ingress.addr, calls)
+	}
+
+	// A non-TLS ingress is a protocol problem, not a certificate change
+	plain, err := Dialer(DirectConfig{
+		Cert:                generateTestCert(t),
+		IngressEndpoint:     newPlaintextServer(t),
+		RootCAs:             x509.NewCertPool(),
+		OnIngressCertChange: onChange,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plain.Dial("tcp", "app.example:80")
+	if len(calls) != 1 {
+		t.Errorf("expected no OnIngressCertChange call for a non-TLS ingress, got %v", calls)
+	}
+}
+
+func TestDialerProbe(t *testing.T) {
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		return testBindingResponse{EndpointID: "ep_probe", Proto: "tcp"}
+	})
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointAliases: map[string]string{"db": "db.prod"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := d.Probe(context.Background(), "db:5432")
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+
+	want := ProbeResult{Hostname: "db.prod", Port: 5432, EndpointID: "ep_probe", Proto: "tcp"}
+	got := *result
+	got.Latency = 0
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if result.Latency <= 0 {
+		t.Errorf("expected positive latency, got %s", result.Latency)
+	}
+
+	reqs := ingress.Requests()
+	if len(reqs) != 1 || reqs[0].Host != "db.prod" || reqs[0].Port != 5432 {
+		t.Errorf("expected one binding request for db.prod:5432, got %+v", reqs)
+	}
+}
+
+func TestDialerProbeTCP(t *testing.T) {
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		return testBindingResponse{ErrorCode: "ERR_NGROK_3200", ErrorMessage: "endpoint not found"}
+	})
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	latency, err := d.ProbeTCP(context.Background())
+	if err != nil {
+		t.Fatalf("ProbeTCP failed: %v", err)
+	}
+	if latency <= 0 {
+		t.Errorf("expected positive latency, got %s", latency)
+	}
+
+	if _, err := d.Probe(context.Background(), "app.example:80"); err == nil {
+		t.Error("expected Probe to fail against an ingress rejecting the binding")
+	}
+}
+
+func TestDialerProbeTCPUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := d.ProbeTCP(context.Background()); err == nil {
+		t.Error("expected ProbeTCP to fail for an unreachable ingress")
+	}
+}
+
+func TestDialContextInfo(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, info, err := d.DialContextInfo(context.Background(), "tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("DialContextInfo failed: %v", err)
+	}
+	conn.Close()
+
+	if info.EndpointID != "ep_app.example" || info.Proto != "http" {
+		t.Errorf("expected endpoint ep_app.example/http, got %s/%s", info.EndpointID, info.Proto)
+	}
+	if info.IngressAddr != ingress.addr {
+		t.Errorf("expected ingress %s, got %s", ingress.addr, info.IngressAddr)
+	}
+	if info.Duration <= 0 {
+		t.Errorf("expected positive duration, got %s", info.Duration)
+	}
+}
+
+func TestDialContextInfoDidResume(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var resumed []bool
+	for i := 0; i < 2; i++ {
+		conn, info, err := d.DialContextInfo(context.Background(), "tcp", "app.example:80")
+		if err != nil {
+			t.Fatalf("DialContextInfo failed: %v", err)
+		}
+		conn.Close()
+		resumed = append(resumed, info.DidResume)
+	}
+
+	// The session ticket arrives with the binding response, so the second
+	// handshake resumes it
+	if resumed[0] || !resumed[1] {
+		t.Errorf("expected only the second dial to resume, got %v", resumed)
+	}
+}
+
+// newPlaintextServer starts a TCP server that answers every connection with
+// a plaintext HTTP response, and returns its address.
+func newPlaintextServer(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestDialerListenNotSupported(t *testing.T) {
+	d, err := Dialer(DirectConfig{Cert: generateTestCert(t)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ln, err := d.Listen(context.Background(), "app.example")
+	if !errors.Is(err, ErrListenNotSupported) {
+		t.Fatalf("expected ErrListenNotSupported, got %v", err)
+	}
+	if ln != nil {
+		t.Error("expected nil listener")
+	}
+}
+
+func TestDialerClone(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+	cert := generateTestCert(t)
+
+	d, err := Dialer(DirectConfig{
+		Cert:       cert,
+		AllowHosts: []string{"*.prod"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	clone, err := d.Clone(func(cfg *DirectConfig) {
+		cfg.IngressEndpoint = ingress.addr
+		cfg.AllowHosts[0] = "*.staging"
+	})
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if !bytes.Equal(clone.tlsConfig.Certificates[0].Certificate[0], cert.Certificate[0]) {
+		t.Error("expected clone to reuse the certificate")
+	}
+	if clone.ingressEndpoint != ingress.addr {
+		t.Errorf("expected clone ingress %s, got %s", ingress.addr, clone.ingressEndpoint)
+	}
+	if d.ingressEndpoint != defaultIngressEndpoint {
+		t.Errorf("expected original ingress to be unchanged, got %s", d.ingressEndpoint)
+	}
+	if d.allowHosts[0] != "*.prod" {
+		t.Errorf("expected original AllowHosts to be unchanged, got %v", d.allowHosts)
+	}
+
+	conn, err := clone.Dial("tcp", "app.staging:80")
+	if err != nil {
+		t.Fatalf("clone dial failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestExportIdentityRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cert := generateTestCert(t)
+	keyPEM, certPEM := encodeTestCert(t, cert)
+
+	d, err := Dialer(DirectConfig{CertStore: NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_export")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exportedCert, exportedKey, operatorID, err := d.ExportIdentity()
+	if err != nil {
+		t.Fatalf("ExportIdentity failed: %v", err)
+	}
+	if operatorID != "k8sop_export" {
+		t.Errorf("expected operator ID k8sop_export, got %s", operatorID)
+	}
+
+	restored, err := tls.X509KeyPair(exportedCert, exportedKey)
+	if err != nil {
+		t.Fatalf("exported material is not a valid key pair: %v", err)
+	}
+
+	ingress := newTestIngress(t, nil)
+	d2, err := Dialer(DirectConfig{Cert: restored, IngressEndpoint: ingress.addr})
+	if err != nil {
+		t.Fatalf("failed to create dialer from exported identity: %v", err)
+	}
+	if !bytes.Equal(d2.tlsConfig.Certificates[0].Certificate[0], cert.Certificate[0]) {
+		t.Error("expected restored dialer to use the exported certificate")
+	}
+
+	conn, err := d2.DialContext(ctx, "tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial with restored identity failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestIngressCAPool(t *testing.T) {
+	cert := generateTestCert(t)
+
+	d, err := Dialer(DirectConfig{Cert: cert})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.IngressCAPool() != nil {
+		t.Error("expected nil pool when verification is disabled")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse cert: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	d, err = Dialer(DirectConfig{Cert: cert, RootCAs: roots})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool := d.IngressCAPool()
+	if pool == nil {
+		t.Fatal("expected non-nil pool when verification is enabled")
+	}
+	if !pool.Equal(roots) {
+		t.Error("expected pool to match configured RootCAs")
+	}
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// generateTestCA creates a self-signed CA certificate and key.
+func generateTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA: %v", err)
+	}
+
+	return ca, key
+}
+
+func generateTestCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	return generateTestCertValidity(t, time.Now(), time.Now().Add(time.Hour))
+}
+
+func generateTestCertValidity(t *testing.T, notBefore, notAfter time.Time) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create cert: %v", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  key,
+	}
+}
+
+// testBindingRequest is a binding request as decoded by testIngress.
+type testBindingRequest struct {
+	Host string
+	Port int
+}
+
+// testBindingResponse is the binding response written by testIngress.
+type testBindingResponse struct {
+	EndpointID   string
+	Proto        string
+	ErrorCode    string
+	ErrorMessage string
+
+	// Close closes the connection right after a successful upgrade, as a
+	// backend refusing the connection would.
+	Close bool
+
+	// Raw, if set, is sent as the response payload in place of the fields
+	// above, and the connection is closed.
+	Raw []byte
+}
+
+// testIngress is a fake ngrok ingress that terminates TLS, answers binding
+// requests, and then echoes data back on successfully upgraded connections.
+type testIngress struct {
+	addr string
+
+	mu       sync.Mutex
+	requests []testBindingRequest
+}
+
+// newTestIngress starts a fake ingress. If respond is nil, every request is
+// accepted as an http endpoint.
+func newTestIngress(t *testing.T, respond func(req testBindingRequest) testBindingResponse) *testIngress {
+	t.Helper()
+	return newTestIngressWithCert(t, generateTestCert(t), respond)
+}
+
+// newTestIngressWithCert starts a fake ingress serving the given certificate.
+func newTestIngressWithCert(t *testing.T, cert tls.Certificate, respond func(req testBindingRequest) testBindingResponse) *testIngress {
+	t.Helper()
+
+	if respond == nil {
+		respond = func(req testBindingRequest) testBindingResponse {
+			return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http"}
+		}
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ingress := &testIngress{addr: ln.Addr().String()}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ingress.serve(conn, respond)
+		}
+	}()
+
+	return ingress
+}
+
+// Requests returns the binding requests received so far.
+func (i *testIngress) Requests() []testBindingRequest {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]testBindingRequest(nil), i.requests...)
+}
+
+func (i *testIngress) serve(conn net.Conn, respond func(req testBindingRequest) testBindingResponse) {
+	defer conn.Close()
+
+	req, err := readTestBindingRequest(conn)
+	if err != nil {
+		return
+	}
+
+	i.mu.Lock()
+	i.requests = append(i.requests, req)
+	i.mu.Unlock()
+
+	resp := respond(req)
+	if err := writeTestBindingResponse(conn, resp); err != nil {
+		return
+	}
+
+	if resp.ErrorCode != "" || resp.ErrorMessage != "" || resp.Close || resp.Raw != nil {
+		return
+	}
+
+	io.Copy(conn, conn)
+}
+
+func readTestBindingRequest(conn net.Conn) (testBindingRequest, error) {
+	var length uint16
+	if err := binary.Read(conn, binary.LittleEndian, &length); err != nil {
+		return testBindingRequest{}, err
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return testBindingRequest{}, err
+	}
+
+	var req testBindingRequest
+	pos := 0
+	for pos < len(buf) {
+		tag := buf[pos]
+		pos++
+
+		switch tag {
+		case 0x0a:
+			n, m := consumeVarint(buf[pos:])
+			pos += m
+			req.Host = string(buf[pos : pos+int(n)])
+			pos += int(n)
+		case 0x10:
+			v, m := consumeVarint(buf[pos:])
+			pos += m
+			req.Port = int(v)
+		default:
+			return req, fmt.Errorf("unexpected tag 0x%x", tag)
+		}
+	}
+
+	return req, nil
+}
+
+func writeTestBindingResponse(conn net.Conn, resp testBindingResponse) error {
+	var buf []byte
+	for i, value := range []string{resp.EndpointID, resp.Proto, resp.ErrorCode, resp.ErrorMessage} {
+		if value == "" {
+			continue
+		}
+		buf = append(buf, byte((i+1)<<3|2))
+		buf = appendVarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+	}
+	if resp.Raw != nil {
+		buf = resp.Raw
+	}
+
+	if err := binary.Write(conn, binary.LittleEndian, uint16(len(buf))); err != nil {
+		return err
+	}
+
+	_, err := conn.Write(buf)
+	return err
+}
+
+type staticEndpointSource []Endpoint
+
+func (s staticEndpointSource) List(ctx context.Context) ([]Endpoint, error) {
+	return append([]Endpoint(nil), s...), nil
+}
+
+func TestDiscoveryDialerEndpointSource(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+
+	u, _ := url.Parse("tcp://db.ns:5432")
+	d, err := DiscoveryDialer(ctx, Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  staticEndpointSource{{ID: "ep_db", URL: u}},
+	})
+	if err != nil {
+		t.Fatalf("expected no API key to be needed with Cert and EndpointSource, got %v", err)
+	}
+
+	endpoints, err := d.Endpoints(ctx)
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].ID != "ep_db" {
+		t.Fatalf("expected endpoints from source, got %+v", endpoints)
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", "tcp://db.ns")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	reqs := ingress.Requests()
+	if len(reqs) != 1 || reqs[0].Host != "db.ns" || reqs[0].Port != 5432 {
+		t.Errorf("expected binding request for db.ns:5432 from the source, got %+v", reqs)
+	}
+}
+
+func TestDiscoveryDialerEndpointSourceValidation(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+
+	u, _ := url.Parse("HTTP://App.Example:80/")
+	source := staticEndpointSource{{ID: "ep_app", URL: u}}
+	d, err := DiscoveryDialer(ctx, Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  &source,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	endpoints, err := d.Endpoints(ctx)
+	if err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].URL.String() != "http://app.example" {
+		t.Fatalf("expected the source URL to be normalized, got %+v", endpoints)
+	}
+	if u.Host != "App.Example:80" {
+		t.Errorf("expected the source's URL to be left unmodified, got %s", u)
+	}
+
+	// An endpoint without a URL is rejected rather than cached
+	source = append(source, Endpoint{ID: "ep_broken"})
+	if _, err := d.ForceRefresh(ctx); err == nil || !strings.Contains(err.Error(), "ep_broken") {
+		t.Fatalf("expected an error naming the invalid endpoint, got %v", err)
+	}
+	conn, err := d.Dial("tcp", "http://app.example")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDiscoveryDialerRequiresAPIKeyWithoutSource(t *testing.T) {
+	_, err := DiscoveryDialer(context.Background(), Config{Cert: generateTestCert(t)})
+	if err == nil {
+		t.Fatal("expected error without APIKey or EndpointSource")
+	}
+}
+
+// stalledEndpointSource blocks until the discovery context is done.
+type stalledEndpointSource struct{}
+
+func (stalledEndpointSource) List(ctx context.Context) ([]Endpoint, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDiscoveryTimeout(t *testing.T) {
+	d, err := DiscoveryDialer(context.Background(), Config{
+		Cert:             generateTestCert(t),
+		EndpointSource:   stalledEndpointSource{},
+		DiscoveryTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := d.Endpoints(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded without a caller deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected discovery to stop after DiscoveryTimeout, took %s", elapsed)
+	}
+
+	// A caller deadline is used instead of the safety timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := d.Endpoints(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected caller deadline to be respected, returned after %s", elapsed)
+	}
+}
+
+// flakyEndpointSource fails its first List call.
+type flakyEndpointSource struct {
+	endpoints []Endpoint
+	calls     atomic.Int32
+}
+
+func (s *flakyEndpointSource) List(ctx context.Context) ([]Endpoint, error) {
+	if s.calls.Add(1) == 1 {
+		return nil, errors.New("API unavailable")
+	}
+	return append([]Endpoint(nil), s.endpoints...), nil
+}
+
+func TestDiscoverOnStart(t *testing.T) {
+	ctx := context.Background()
+	u, _ := url.Parse("tcp://db.ns:5432")
+	endpoints := []Endpoint{{ID: "ep_db", URL: u}}
+
+	d, err := DiscoveryDialer(ctx, Config{
+		Cert:            generateTestCert(t),
+		EndpointSource:  staticEndpointSource(endpoints),
+		DiscoverOnStart: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cached, _ := d.cachedEndpoints(); len(cached) != 1 {
+		t.Errorf("expected endpoints discovered at construction, got %+v", cached)
+	}
+
+	if _, err := DiscoveryDialer(ctx, Config{
+		Cert:            generateTestCert(t),
+		EndpointSource:  &flakyEndpointSource{endpoints: endpoints},
+		DiscoverOnStart: true,
+	}); err == nil {
+		t.Error("expected a failed initial discovery to be returned")
+	}
+}
+
+func TestIgnoreInitialDiscoveryError(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+	u, _ := url.Parse("tcp://db.ns:5432")
+	source := &flakyEndpointSource{endpoints: []Endpoint{{ID: "ep_db", URL: u}}}
+
+	d, err := DiscoveryDialer(ctx, Config{
+		Cert:                        generateTestCert(t),
+		IngressEndpoint:             ingress.addr,
+		EndpointSource:              source,
+		DiscoverOnStart:             true,
+		IgnoreInitialDiscoveryError: true,
+	})
+	if err != nil {
+		t.Fatalf("expected construction to succeed despite the failed discovery, got %v", err)
+	}
+
+	// Dials not needing the cache work immediately
+	conn, err := d.DialContext(ctx, "tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+	conn, err = d.DialContext(ctx, "tcp", "tcp://db.ns")
+	if err != nil {
+		t.Fatalf("dial using the recovered cache failed: %v", err)
+	}
+	conn.Close()
+}
+
+// blockingEndpointSource blocks List until ctx is done.
+type blockingEndpointSource struct{}
+
+func (blockingEndpointSource) List(ctx context.Context) ([]Endpoint, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStartupTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	newDialer := func(ignore bool) (*discoveryDialer, error) {
+		return DiscoveryDialer(ctx, Config{
+			Cert:                        generateTestCert(t),
+			EndpointSource:              blockingEndpointSource{},
+			DiscoverOnStart:             true,
+			IgnoreInitialDiscoveryError: ignore,
+			StartupTimeout:              50 * time.Millisecond,
+		})
+	}
+
+	start := time.Now()
+	if _, err := newDialer(false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected startup to exceed its deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("construction took %v, expected ~50ms", elapsed)
+	}
+
+	d, err := newDialer(true)
+	if err != nil {
+		t.Fatalf("expected construction to succeed with IgnoreInitialDiscoveryError, got %v", err)
+	}
+	if cached, _ := d.cachedEndpoints(); len(cached) != 0 {
+		t.Errorf("expected an empty cache, got %+v", cached)
+	}
+}
+
+func TestDialAuthorizer(t *testing.T) {
+	type principalKey struct{}
+	ingress := newTestIngress(t, nil)
+	u, _ := url.Parse("tcp://db.ns:5432")
+	errForbidden := errors.New("forbidden")
+
+	var authorized []Endpoint
+	d, err := DiscoveryDialer(context.Background(), Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  staticEndpointSource([]Endpoint{{ID: "ep_db", URL: u}}),
+		DiscoverOnStart: true,
+		DialAuthorizer: func(ctx context.Context, ep Endpoint) error {
+			authorized = append(authorized, ep)
+			if ctx.Value(principalKey{}) != "admin" {
+				return errForbidden
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = d.DialContext(context.Background(), "tcp", "tcp://db.ns")
+	var denied *AuthorizationDeniedError
+	if !errors.As(err, &denied) || !errors.Is(err, errForbidden) {
+		t.Fatalf("expected AuthorizationDeniedError wrapping the veto, got %v", err)
+	}
+	if denied.Endpoint.ID != "ep_db" {
+		t.Errorf("expected the discovered endpoint, got %+v", denied.Endpoint)
+	}
+	if n := len(ingress.Requests()); n != 0 {
+		t.Errorf("expected no binding request for a denied dial, got %d", n)
+	}
+
+	ctx := context.WithValue(context.Background(), principalKey{}, "admin")
+	conn, err := d.DialContext(ctx, "tcp", "tcp://db.ns")
+	if err != nil {
+		t.Fatalf("expected permitted dial to succeed, got %v", err)
+	}
+	conn.Close()
+
+	// Undiscovered endpoints are authorized by hostname and port
+	conn, err = d.DialContext(ctx, "tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+	if last := authorized[len(authorized)-1]; last.ID != "" || last.URL.Host != "app.example:80" {
+		t.Errorf("unexpected endpoint for an undiscovered host: %+v", last)
+	}
+}
+
+func TestBindingRequestHook(t *testing.T) {
+	ingress := newTestIngress(t, nil)
+	u, _ := url.Parse("tcp://db.ns:5432")
+
+	d, err := DiscoveryDialer(context.Background(), Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  staticEndpointSource([]Endpoint{{ID: "ep_db", URL: u}}),
+		DiscoverOnStart: true,
+		BindingRequestHook: func(base *ConnRequest, ep Endpoint) {
+			if ep.ID == "ep_db" {
+				base.Host = "db.ns.svc.cluster.local"
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, address := range []string{"tcp://db.ns", "app.example:80"} {
+		conn, err := d.DialContext(context.Background(), "tcp", address)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", address, err)
+		}
+		conn.Close()
+	}
+
+	reqs := ingress.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 binding requests, got %d", len(reqs))
+	}
+	if reqs[0].Host != "db.ns.svc.cluster.local" || reqs[0].Port != 5432 {
+		t.Errorf("expected the hook's host in the binding request, got %+v", reqs[0])
+	}
+	if reqs[1].Host != "app.example" {
+		t.Errorf("expected an unmodified request, got %+v", reqs[1])
+	}
+}
+
+func TestConfigRejectsDiscoverOnStartWithLazyProvision(t *testing.T) {
+	cfg := Config{DiscoverOnStart: true, LazyProvision: true}
+	if err := cfg.setDefaults(); err == nil {
+		t.Error("expected error for DiscoverOnStart with LazyProvision")
+	}
+}
+
+func TestDialerOnProtoMismatch(t *testing.T) {
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http"}
+	})
+
+	var mismatches [][3]string
+	d, err := Dialer(DirectConfig{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		OnProtoMismatch: func(address, requested, bound string) {
+			mismatches = append(mismatches, [3]string{address, requested, bound})
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, address := range []string{"tcp://app.example:5432", "http://app.example", "app.example:80"} {
+		conn, err := d.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", address, err)
+		}
+		conn.Close()
+	}
+
+	want := [][3]string{{"tcp://app.example:5432", "tcp", "http"}}
+	if !reflect.DeepEqual(mismatches, want) {
+		t.Errorf("expected mismatches %v, got %v", want, mismatches)
+	}
+}
+
+func TestRenewAfter(t *testing.T) {
+	now := time.Now()
+	cert := generateTestCertValidity(t, now.Add(-time.Hour), now.Add(2*time.Hour))
+
+	d, err := Dialer(DirectConfig{Cert: cert, RenewBefore: 30 * time.Minute})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	due := leaf.NotAfter.Add(-30 * time.Minute)
+
+	for _, tt := range []struct {
+		at   time.Time
+		want time.Duration
+	}{
+		{due.Add(-time.Hour), time.Hour},
+		{due.Add(-time.Minute), time.Minute},
+		{due.Add(time.Minute), 0},
+	} {
+		got, err := d.renewAfter(tt.at)
+		if err != nil {
+			t.Fatalf("renewAfter failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("renewAfter(%s before due) = %s, want %s", due.Sub(tt.at), got, tt.want)
+		}
+	}
+
+	// By default renewal is due with a third of the validity period left
+	d, err = Dialer(DirectConfig{Cert: cert})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := d.renewAfter(leaf.NotBefore)
+	if want := 2 * leaf.NotAfter.Sub(leaf.NotBefore) / 3; got != want {
+		t.Errorf("expected default renewal after %s, got %s", want, got)
 	}
 }
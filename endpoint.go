@@ -2,8 +2,11 @@ package ngrokd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -11,6 +14,17 @@ import (
 type Endpoint struct {
 	ID  string
 	URL *url.URL
+
+	// Proto is the endpoint protocol reported by the API: http, https, tls
+	// or tcp. It may be empty for endpoints from a custom EndpointSource.
+	Proto string
+
+	// Metadata is the endpoint's user-supplied metadata, if any.
+	Metadata string
+
+	// Reachable reports whether a binding upgrade to the endpoint succeeded
+	// during discovery. Only set when Config.VerifyOnDiscover is true.
+	Reachable bool
 }
 
 // Hostname returns the hostname from the endpoint URL.
@@ -18,12 +32,89 @@ func (e Endpoint) Hostname() string {
 	return e.URL.Hostname()
 }
 
-// parseAddress parses an address string into hostname and port.
-func parseAddress(address string) (hostname string, port int, err error) {
+// DialURL returns a canonical URL for dialing the endpoint, built from its
+// proto, hostname and port rather than taken from the URL the API returned:
+// http:// and https:// URLs omit their default port, tls:// and tcp:// URLs
+// include it. The URL scheme is used when Proto is empty.
+func (e Endpoint) DialURL() string {
+	scheme := strings.ToLower(e.Proto)
+	if scheme == "" {
+		scheme = strings.ToLower(e.URL.Scheme)
+	}
+
+	host := e.URL.Hostname()
+	port, err := defaultPort(scheme)
+	if p := e.URL.Port(); p != "" {
+		port, err = strconv.Atoi(p)
+	}
+
+	omitPort := (scheme == "http" && port == 80) || (scheme == "https" && port == 443)
+	if err != nil || omitPort {
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		return scheme + "://" + host
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// port returns the port from the endpoint URL, or the default port of its
+// scheme. It reports false for tcp URLs without a port.
+func (e Endpoint) port() (int, bool) {
+	if p := e.URL.Port(); p != "" {
+		port, err := strconv.Atoi(p)
+		return port, err == nil
+	}
+	port, err := defaultPort(e.URL.Scheme)
+	return port, err == nil
+}
+
+// EndpointSource lists the endpoints a DiscoveryDialer can reach.
+type EndpointSource interface {
+	List(ctx context.Context) ([]Endpoint, error)
+}
+
+// apiEndpointSource lists the endpoints bound to an operator via the ngrok API.
+type apiEndpointSource struct {
+	client     *apiClient
+	operatorID string
+	merge      func(existing, incoming Endpoint) Endpoint
+	dedupByID  bool
+}
+
+func (s *apiEndpointSource) List(ctx context.Context) ([]Endpoint, error) {
+	return discoverEndpoints(ctx, s.client, s.operatorID, s.merge, s.dedupByID)
+}
+
+// errPortRequired is returned by parseAddress for tcp:// addresses without a port.
+var errPortRequired = errors.New("scheme requires explicit port")
+
+// defaultPort returns the port used for a scheme when an address or endpoint
+// URL has none: 80 for http, 443 for https and tls, and errPortRequired for
+// tcp. Addresses without a scheme and unknown schemes use 80.
+//
+// An explicit port always takes precedence. Otherwise the discovery dialer
+// prefers the port of a discovered endpoint with the same scheme and
+// hostname, and looks up tcp ports that way before failing.
+func defaultPort(scheme string) (int, error) {
+	switch scheme {
+	case "https", "tls":
+		return 443, nil
+	case "tcp":
+		return 0, fmt.Errorf("%s %w", scheme, errPortRequired)
+	default:
+		return 80, nil
+	}
+}
+
+// parseAddress parses an address string into hostname and port. explicit
+// reports whether the port was given in the address rather than derived from
+// its scheme.
+func parseAddress(address string) (hostname string, port int, explicit bool, err error) {
 	if strings.Contains(address, "://") {
 		u, err := url.Parse(address)
 		if err != nil {
-			return "", 0, fmt.Errorf("invalid URL: %w", err)
+			return "", 0, false, fmt.Errorf("invalid URL: %w", err)
 		}
 
 		hostname = u.Hostname()
@@ -31,36 +122,34 @@ func parseAddress(address string) (hostname string, port int, err error) {
 
 		if portStr != "" {
 			if _, err := fmt.Sscanf(portStr, "%d", &port); err != nil {
-				return "", 0, fmt.Errorf("invalid port: %w", err)
-			}
-		} else {
-			switch u.Scheme {
-			case "http":
-				port = 80
-			case "tcp", "tls":
-				return "", 0, fmt.Errorf("%s scheme requires explicit port", u.Scheme)
-			default:
-				port = 80
+				return "", 0, false, fmt.Errorf("invalid port: %w", err)
 			}
+			return hostname, port, true, nil
 		}
-		return hostname, port, nil
+		if port, err = defaultPort(u.Scheme); err != nil {
+			return "", 0, false, err
+		}
+		return hostname, port, false, nil
 	}
 
 	if idx := strings.LastIndex(address, ":"); idx != -1 {
 		hostname = address[:idx]
 		portStr := address[idx+1:]
 		if _, err := fmt.Sscanf(portStr, "%d", &port); err != nil {
-			return "", 0, fmt.Errorf("invalid port: %w", err)
+			return "", 0, false, fmt.Errorf("invalid port: %w", err)
 		}
-		return hostname, port, nil
+		return hostname, port, true, nil
 	}
 
-	// Just hostname, default to 80
-	return address, 80, nil
+	// Just hostname
+	port, _ = defaultPort("")
+	return address, port, false, nil
 }
 
-// discoverEndpoints fetches bound endpoints from ngrok API.
-func discoverEndpoints(ctx context.Context, client *apiClient, operatorID string) ([]Endpoint, error) {
+// discoverEndpoints fetches bound endpoints from ngrok API. Endpoints with the
+// same normalized URL, or the same ID if dedupByID is set, are combined by
+// merge, or the first is kept if merge is nil.
+func discoverEndpoints(ctx context.Context, client *apiClient, operatorID string, merge func(existing, incoming Endpoint) Endpoint, dedupByID bool) ([]Endpoint, error) {
 	if operatorID == "" {
 		return nil, fmt.Errorf("operator ID not set")
 	}
@@ -70,25 +159,100 @@ func discoverEndpoints(ctx context.Context, client *apiClient, operatorID string
 		return nil, err
 	}
 
-	// Deduplicate by URL
-	seen := make(map[string]bool)
+	// Deduplicate by normalized URL, or by ID
+	seen := make(map[string]int)
 	endpoints := make([]Endpoint, 0, len(apiEndpoints))
 	for _, ep := range apiEndpoints {
-		if seen[ep.URL] {
+		u, err := url.Parse(ep.URL)
+		if err != nil {
 			continue
 		}
-		seen[ep.URL] = true
+		normalizeEndpointURL(u)
 
-		u, err := url.Parse(ep.URL)
-		if err != nil {
+		endpoint := Endpoint{
+			ID:       ep.ID,
+			URL:      u,
+			Proto:    ep.Proto,
+			Metadata: ep.Metadata,
+		}
+
+		key := u.String()
+		if dedupByID {
+			key = ep.ID
+		}
+		if i, ok := seen[key]; ok {
+			if merge != nil {
+				endpoints[i] = merge(endpoints[i], endpoint)
+			}
 			continue
 		}
+		seen[key] = len(endpoints)
 
-		endpoints = append(endpoints, Endpoint{
-			ID:  ep.ID,
-			URL: u,
-		})
+		endpoints = append(endpoints, endpoint)
 	}
 
 	return endpoints, nil
 }
+
+// normalizeEndpointURL lowercases the scheme and host and strips default ports
+// and trailing slashes, so variants of the same endpoint URL compare equal.
+func normalizeEndpointURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+}
+
+// normalizeEndpoints validates endpoints given in configuration or listed by
+// a custom EndpointSource, described by kind in errors, and returns copies
+// with normalized URLs, matching those found by API discovery.
+func normalizeEndpoints(kind string, endpoints []Endpoint) ([]Endpoint, error) {
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]Endpoint, len(endpoints))
+	for i, ep := range endpoints {
+		if ep.URL == nil || ep.URL.Scheme == "" || ep.URL.Hostname() == "" {
+			return nil, fmt.Errorf("invalid %s endpoint %q: URL must have a scheme and host", kind, ep.ID)
+		}
+		u := *ep.URL
+		normalizeEndpointURL(&u)
+		ep.URL = &u
+		normalized[i] = ep
+	}
+	return normalized, nil
+}
+
+// overlayEndpoints returns endpoints with each of static added, replacing any
+// endpoint with the same URL.
+func overlayEndpoints(endpoints, static []Endpoint) []Endpoint {
+	if len(static) == 0 {
+		return endpoints
+	}
+
+	overlaid := make(map[string]bool, len(static))
+	for _, ep := range static {
+		overlaid[ep.URL.String()] = true
+	}
+
+	merged := make([]Endpoint, 0, len(endpoints)+len(static))
+	for _, ep := range endpoints {
+		if !overlaid[ep.URL.String()] {
+			merged = append(merged, ep)
+		}
+	}
+	return append(merged, static...)
+}
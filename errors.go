@@ -1,7 +1,212 @@
 package ngrokd
 
-import "errors"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
 
 var (
 	ErrEndpointNotFound = errors.New("endpoint not found")
+
+	// ErrCertKeyMismatch is returned when the certificate issued during provisioning
+	// does not match the generated private key, which usually means the API
+	// signed a different CSR than the one submitted.
+	ErrCertKeyMismatch = errors.New("ngrokd: issued certificate does not match the generated private key")
+
+	// ErrListenNotSupported is returned by Listen. The binding protocol only
+	// carries connections dialed out to endpoints; the ingress cannot push
+	// inbound connections to a dialer. Use ngrok-go to serve an endpoint.
+	ErrListenNotSupported = errors.New("ngrokd: listening is not supported by the binding protocol; use golang.ngrok.com/ngrok to serve endpoints")
+
+	// ErrReconnectable is returned by a ReconnectingConn Read or Write that failed
+	// because the connection dropped, after a new connection was established.
+	// The call can be retried once the protocol has recovered.
+	ErrReconnectable = errors.New("ngrokd: connection dropped and was re-established")
+
+	// ErrIngressNotTLS is wrapped in the TLSHandshakeError returned when the
+	// ingress answers with something other than TLS, which usually means
+	// IngressEndpoint points at a plaintext listener or the wrong port.
+	ErrIngressNotTLS = errors.New("ngrokd: ingress did not respond with TLS; check that IngressEndpoint is a TLS listener and the port is correct")
+
+	// ErrOperatorNotFound is returned when the API reports that an operator no
+	// longer exists and MissingOperatorPolicy is MissingOperatorError.
+	ErrOperatorNotFound = errors.New("ngrokd: operator not found")
+
+	// ErrInvalidEndpointSelector is wrapped in the ProvisioningError returned
+	// when the API rejects EndpointSelectors, which need to be valid CEL.
+	// The API has no error code for this, so it is recognized by the message
+	// and is best-effort: check ProvisioningError.Msg when it matters.
+	ErrInvalidEndpointSelector = errors.New("ngrokd: invalid endpoint selector")
+
+	// ErrBodyTooLarge is returned by ReadBodyLimit when a response body
+	// exceeds the limit.
+	ErrBodyTooLarge = errors.New("ngrokd: response body too large")
 )
+
+// HostDeniedError is returned when a dial is rejected by AllowHosts or DenyHosts.
+// The ingress is never contacted for a denied host.
+type HostDeniedError struct {
+	Hostname string
+}
+
+func (e *HostDeniedError) Error() string {
+	return fmt.Sprintf("host %q denied by dialer host policy", e.Hostname)
+}
+
+// AuthorizationDeniedError is returned when Config.DialAuthorizer vetoes a
+// dial. The ingress is never contacted for a denied dial.
+type AuthorizationDeniedError struct {
+	Endpoint Endpoint
+	Err      error
+}
+
+func (e *AuthorizationDeniedError) Error() string {
+	return fmt.Sprintf("dial to %s denied by authorizer: %v", e.Endpoint.URL.Host, e.Err)
+}
+
+func (e *AuthorizationDeniedError) Unwrap() error {
+	return e.Err
+}
+
+// BindingProtocolError is returned when the ingress response to a binding
+// upgrade cannot be decoded. With CaptureFailedHandshakes set, Request and
+// Response hold the hex-encoded frames, each truncated to 4 KiB, for
+// attaching to a support request.
+type BindingProtocolError struct {
+	Err      error
+	Request  string
+	Response string
+}
+
+func (e *BindingProtocolError) Error() string {
+	return fmt.Sprintf("invalid binding response: %v", e.Err)
+}
+
+func (e *BindingProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// EndpointQuarantinedError is returned when a dial is rejected because the
+// endpoint's binding upgrades kept failing; see Config.Quarantine. The
+// ingress is not contacted until Until.
+type EndpointQuarantinedError struct {
+	Hostname string
+	Until    time.Time
+}
+
+func (e *EndpointQuarantinedError) Error() string {
+	return fmt.Sprintf("endpoint %s is quarantined after repeated binding failures until %s", e.Hostname, e.Until.Format(time.RFC3339))
+}
+
+// ProvisioningError is returned when the API accepts an operator request but
+// issues no certificate. ErrorCode and Msg carry the API's explanation, if
+// any. It wraps ErrInvalidEndpointSelector when Msg reports that the selectors
+// were rejected; that classification is best-effort.
+type ProvisioningError struct {
+	OperatorID string
+	ErrorCode  string
+	Msg        string
+
+	invalidSelector bool
+}
+
+func (e *ProvisioningError) Error() string {
+	msg := "no certificate in response"
+	if e.Msg != "" {
+		msg += ": " + e.Msg
+	}
+	if e.ErrorCode != "" {
+		msg += " (" + e.ErrorCode + ")"
+	}
+	return msg
+}
+
+func (e *ProvisioningError) Unwrap() error {
+	if e.invalidSelector {
+		return ErrInvalidEndpointSelector
+	}
+	return nil
+}
+
+// invalidSelectorMsg prefixes the API's explanation when it rejects
+// EndpointSelectors.
+const invalidSelectorMsg = "invalid endpoint selector"
+
+// newProvisioningError explains an operator response that carries no
+// certificate.
+func newProvisioningError(operator *operatorResponse) *ProvisioningError {
+	return &ProvisioningError{
+		OperatorID:      operator.ID,
+		ErrorCode:       operator.ErrorCode,
+		Msg:             operator.Msg,
+		invalidSelector: strings.HasPrefix(strings.ToLower(operator.Msg), invalidSelectorMsg),
+	}
+}
+
+// BackendRefusedError is returned by the first Read on a bound connection,
+// when Config.DetectImmediateClose is set, if the ingress accepted the binding
+// but the connection was closed without any data right after. It wraps io.EOF.
+type BackendRefusedError struct {
+	Hostname   string
+	Port       int
+	EndpointID string
+}
+
+func (e *BackendRefusedError) Error() string {
+	return fmt.Sprintf("endpoint %s (%s:%d) closed the connection immediately after binding; the backend likely refused it", e.EndpointID, e.Hostname, e.Port)
+}
+
+func (e *BackendRefusedError) Unwrap() error {
+	return io.EOF
+}
+
+// TLSHandshakeError is returned when the TLS handshake with the ingress fails
+// because of a certificate or protocol problem, such as an unknown authority,
+// an expired certificate, or a non-TLS response. These usually indicate a
+// trust or configuration issue rather than a transient network failure, so
+// retrying the dial will not help.
+type TLSHandshakeError struct {
+	IngressEndpoint string
+	Err             error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	return fmt.Sprintf("TLS handshake %s: %v", e.IngressEndpoint, e.Err)
+}
+
+func (e *TLSHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// isTLSHandshakeFailure reports whether err is a certificate or protocol
+// failure rather than a network error during the handshake.
+func isTLSHandshakeFailure(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var verifyErr *tls.CertificateVerificationError
+	return errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &verifyErr)
+}
+
+// isNotTLS reports whether err is a handshake failure caused by a peer that
+// does not speak TLS.
+func isNotTLS(err error) bool {
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &recordErr)
+}
+
+// isCertVerificationFailure reports whether err is a failure to verify the
+// peer certificate, as opposed to a protocol failure.
+func isCertVerificationFailure(err error) bool {
+	return isTLSHandshakeFailure(err) && !isNotTLS(err)
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -12,6 +11,9 @@ import (
 	ngrokd "github.com/ngrok-oss/ngrokd-go"
 )
 
+// maxBodySize caps how much of the response is buffered for printing.
+const maxBodySize = 1 << 20
+
 func main() {
 	if err := run(context.Background()); err != nil {
 		log.Fatal(err)
@@ -65,9 +67,11 @@ func run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	body, err := ngrokd.ReadBodyLimit(resp, maxBodySize)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Status: %d\nBody: %s\n", resp.StatusCode, string(body))
 
 	return nil
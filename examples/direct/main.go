@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -11,6 +10,9 @@ import (
 	ngrokd "github.com/ngrok-oss/ngrokd-go"
 )
 
+// maxBodySize caps how much of the response is buffered for printing.
+const maxBodySize = 1 << 20
+
 func main() {
 	if len(os.Args) < 2 {
 		log.Fatal("Usage: direct <endpoint-url>")
@@ -34,8 +36,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Request failed: %v", err)
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	body, err := ngrokd.ReadBodyLimit(resp, maxBodySize)
+	if err != nil {
+		log.Fatalf("Failed to read response: %v", err)
+	}
 	fmt.Printf("Status: %d\nBody: %s\n", resp.StatusCode, string(body))
 }
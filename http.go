@@ -0,0 +1,24 @@
+package ngrokd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ReadBodyLimit reads and closes resp.Body, returning ErrBodyTooLarge instead
+// of buffering more than max bytes. Use it in place of io.ReadAll when the
+// endpoint is not trusted to bound its responses; stream large bodies with
+// io.Copy instead.
+func ReadBodyLimit(resp *http.Response, max int64) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, max)
+	}
+	return body, nil
+}
@@ -0,0 +1,50 @@
+package ngrokd
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// closeRecorder records whether the body was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestReadBodyLimit(t *testing.T) {
+	tests := []struct {
+		body    string
+		max     int64
+		wantErr bool
+	}{
+		{body: "", max: 0},
+		{body: "hello", max: 5},
+		{body: "hello", max: 100},
+		{body: "hello", max: 4, wantErr: true},
+		{body: "hello", max: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		body := &closeRecorder{Reader: strings.NewReader(tt.body)}
+		got, err := ReadBodyLimit(&http.Response{Body: body}, tt.max)
+
+		if tt.wantErr {
+			if !errors.Is(err, ErrBodyTooLarge) {
+				t.Errorf("ReadBodyLimit(%q, %d): expected ErrBodyTooLarge, got %v", tt.body, tt.max, err)
+			}
+		} else if err != nil || string(got) != tt.body {
+			t.Errorf("ReadBodyLimit(%q, %d) = %q, %v", tt.body, tt.max, got, err)
+		}
+		if !body.closed {
+			t.Errorf("ReadBodyLimit(%q, %d): expected body to be closed", tt.body, tt.max)
+		}
+	}
+}
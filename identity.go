@@ -0,0 +1,85 @@
+package ngrokd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+)
+
+// identityVersion is the version of the envelope written by MarshalIdentity.
+const identityVersion = 1
+
+// identityEnvelope is the serialized form of a dialer identity.
+type identityEnvelope struct {
+	Version         int    `json:"version"`
+	Cert            string `json:"cert"`
+	Key             string `json:"key"`
+	OperatorID      string `json:"operator_id,omitempty"`
+	IngressEndpoint string `json:"ingress_endpoint,omitempty"`
+}
+
+func marshalIdentity(b *binder, operatorID string) ([]byte, error) {
+	certPEM, keyPEM, err := b.exportCert()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(identityEnvelope{
+		Version:         identityVersion,
+		Cert:            string(certPEM),
+		Key:             string(keyPEM),
+		OperatorID:      operatorID,
+		IngressEndpoint: b.ingressEndpoint,
+	})
+}
+
+// MarshalIdentity returns the dialer's certificate, private key, operator ID
+// and ingress endpoint as a single blob for DialerFromIdentity.
+//
+// The blob contains the private key. Handle it as a secret, like ExportIdentity.
+func (d *dialer) MarshalIdentity() ([]byte, error) {
+	return marshalIdentity(d.binder, d.operatorID)
+}
+
+// MarshalIdentity returns the dialer's certificate, private key, operator ID
+// and ingress endpoint as a single blob for DialerFromIdentity.
+//
+// The blob contains the private key. Handle it as a secret, like ExportIdentity.
+func (d *discoveryDialer) MarshalIdentity() ([]byte, error) {
+	if err := d.ensureProvisioned(context.Background()); err != nil {
+		return nil, err
+	}
+	operatorID, _ := d.identity()
+	return marshalIdentity(d.binder, operatorID)
+}
+
+// DialerFromIdentity creates a dialer from a blob written by MarshalIdentity.
+// The blob's ingress endpoint is used unless cfg.IngressEndpoint is set;
+// cfg.Cert and cfg.CertStore are ignored.
+func DialerFromIdentity(blob []byte, cfg DirectConfig) (*dialer, error) {
+	var env identityEnvelope
+	if err := json.Unmarshal(blob, &env); err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	if env.Version != identityVersion {
+		return nil, fmt.Errorf("unsupported identity version %d", env.Version)
+	}
+
+	cert, err := tls.X509KeyPair([]byte(env.Cert), []byte(env.Key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+
+	cfg.Cert = cert
+	if cfg.IngressEndpoint == "" {
+		cfg.IngressEndpoint = env.IngressEndpoint
+	}
+
+	d, err := Dialer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	d.operatorID = env.OperatorID
+
+	return d, nil
+}
@@ -0,0 +1,74 @@
+package ngrokd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalIdentityRoundTrip(t *testing.T) {
+	cert := generateTestCert(t)
+	keyPEM, certPEM := encodeTestCert(t, cert)
+	ingress := newTestIngress(t, nil)
+
+	d, err := Dialer(DirectConfig{
+		CertStore:       NewMemoryStoreWithCert(keyPEM, certPEM, "k8sop_blob"),
+		IngressEndpoint: ingress.addr,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	blob, err := d.MarshalIdentity()
+	if err != nil {
+		t.Fatalf("MarshalIdentity failed: %v", err)
+	}
+
+	restored, err := DialerFromIdentity(blob, DirectConfig{})
+	if err != nil {
+		t.Fatalf("DialerFromIdentity failed: %v", err)
+	}
+
+	_, _, operatorID, _ := restored.ExportIdentity()
+	if operatorID != "k8sop_blob" {
+		t.Errorf("expected operator ID k8sop_blob, got %s", operatorID)
+	}
+	if restored.ingressEndpoint != ingress.addr {
+		t.Errorf("expected ingress %s from the blob, got %s", ingress.addr, restored.ingressEndpoint)
+	}
+	if !bytes.Equal(restored.clientTLSConfig().Certificates[0].Certificate[0], cert.Certificate[0]) {
+		t.Error("expected restored dialer to use the marshaled certificate")
+	}
+
+	conn, err := restored.DialContext(context.Background(), "tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial with restored identity failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialerFromIdentityRejectsMalformedBlob(t *testing.T) {
+	keyPEM, certPEM := encodeTestCert(t, generateTestCert(t))
+	_, otherCertPEM := encodeTestCert(t, generateTestCert(t))
+
+	envelope := func(version int, cert, key []byte) []byte {
+		blob, _ := json.Marshal(identityEnvelope{Version: version, Cert: string(cert), Key: string(key)})
+		return blob
+	}
+
+	tests := map[string][]byte{
+		"not json":         []byte("bm90IGpzb24="),
+		"unknown version":  envelope(2, certPEM, keyPEM),
+		"mismatched pair":  envelope(identityVersion, otherCertPEM, keyPEM),
+		"missing material": envelope(identityVersion, nil, nil),
+	}
+
+	for name, blob := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DialerFromIdentity(blob, DirectConfig{}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
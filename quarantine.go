@@ -0,0 +1,112 @@
+package ngrokd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// defaultQuarantineCooldown is how long an endpoint stays quarantined when
+// QuarantineConfig.Cooldown is zero.
+const defaultQuarantineCooldown = 30 * time.Second
+
+// QuarantineConfig stops dialing an endpoint whose binding upgrades keep
+// failing, sparing the handshakes a dead endpoint would cost.
+type QuarantineConfig struct {
+	// Threshold is how many consecutive failed binding upgrades quarantine an
+	// endpoint. Dials to it then fail with an EndpointQuarantinedError
+	// without contacting the ingress.
+	// Default: 0 (disabled)
+	Threshold int
+
+	// Cooldown is how long an endpoint stays quarantined. The first dial
+	// after it is let through; another failure quarantines it again. A
+	// successful Probe or VerifyOnDiscover check releases it early.
+	// Default: 30s
+	Cooldown time.Duration
+
+	// OnChange is called when an endpoint enters quarantine, including
+	// again after its cooldown lapsed, and when a successful binding upgrade
+	// releases it. A cooldown lapsing on its own is not reported.
+	// Default: nil
+	OnChange func(hostname string, quarantined bool)
+}
+
+// quarantine tracks failed binding upgrades per endpoint hostname.
+type quarantine struct {
+	threshold int
+	cooldown  time.Duration
+	onChange  func(hostname string, quarantined bool)
+	logger    logr.Logger
+
+	mu        sync.Mutex
+	endpoints map[string]*quarantineState
+}
+
+type quarantineState struct {
+	failures int
+	until    time.Time // zero while not quarantined
+}
+
+// newQuarantine returns nil if cfg disables quarantine.
+func newQuarantine(cfg QuarantineConfig, logger logr.Logger) *quarantine {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	return &quarantine{
+		threshold: cfg.Threshold,
+		cooldown:  cfg.Cooldown,
+		onChange:  cfg.OnChange,
+		logger:    logger,
+		endpoints: make(map[string]*quarantineState),
+	}
+}
+
+// check returns an EndpointQuarantinedError if hostname is quarantined.
+func (q *quarantine) check(hostname string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if st, ok := q.endpoints[hostname]; ok && time.Now().Before(st.until) {
+		return &EndpointQuarantinedError{Hostname: hostname, Until: st.until}
+	}
+	return nil
+}
+
+// record counts the outcome of a binding upgrade to hostname.
+func (q *quarantine) record(hostname string, ok bool) {
+	q.mu.Lock()
+	st := q.endpoints[hostname]
+	var changed bool
+	switch {
+	case ok && st != nil:
+		changed = !st.until.IsZero()
+		delete(q.endpoints, hostname)
+	case !ok:
+		if st == nil {
+			st = &quarantineState{}
+			q.endpoints[hostname] = st
+		}
+		st.failures++
+		if st.failures >= q.threshold {
+			changed = st.until.IsZero() || !time.Now().Before(st.until)
+			st.until = time.Now().Add(q.cooldown)
+		}
+	}
+	q.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if q.logger.Enabled() {
+		if ok {
+			q.logger.Info("Endpoint released from quarantine", "hostname", hostname)
+		} else {
+			q.logger.Info("Endpoint quarantined after repeated binding failures", "hostname", hostname, "cooldown", q.cooldown)
+		}
+	}
+	if q.onChange != nil {
+		q.onChange(hostname, !ok)
+	}
+}
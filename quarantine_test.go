@@ -0,0 +1,128 @@
+package ngrokd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+
+	var healthy atomic.Bool
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		if req.Host == "dead.example" && !healthy.Load() {
+			return testBindingResponse{ErrorCode: "ERR_NGROK_3200", ErrorMessage: "endpoint offline"}
+		}
+		return testBindingResponse{EndpointID: "ep_" + req.Host, Proto: "http"}
+	})
+
+	var mu sync.Mutex
+	var events []string
+	d, err := DiscoveryDialer(ctx, Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  staticEndpointSource(nil),
+		Quarantine: QuarantineConfig{
+			Threshold: 2,
+			Cooldown:  time.Hour,
+			OnChange: func(hostname string, quarantined bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, fmt.Sprintf("%s %t", hostname, quarantined))
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.Dial("tcp", "dead.example:80"); err == nil {
+			t.Fatal("expected binding error")
+		}
+	}
+
+	var quarantined *EndpointQuarantinedError
+	if _, err := d.Dial("tcp", "dead.example:80"); !errors.As(err, &quarantined) {
+		t.Fatalf("expected EndpointQuarantinedError, got %v", err)
+	}
+	if n := len(ingress.Requests()); n != 2 {
+		t.Errorf("expected the quarantined dial not to reach the ingress, got %d requests", n)
+	}
+
+	// Other endpoints are unaffected
+	conn, err := d.Dial("tcp", "app.example:80")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	// A successful probe releases the endpoint before the cooldown ends
+	healthy.Store(true)
+	if _, err := d.Probe(ctx, "dead.example:80"); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	conn, err = d.Dial("tcp", "dead.example:80")
+	if err != nil {
+		t.Fatalf("expected dial after release to succeed, got %v", err)
+	}
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 || events[0] != "dead.example true" || events[1] != "dead.example false" {
+		t.Errorf("unexpected quarantine events: %v", events)
+	}
+}
+
+func TestQuarantineCooldown(t *testing.T) {
+	ingress := newTestIngress(t, func(req testBindingRequest) testBindingResponse {
+		return testBindingResponse{ErrorCode: "ERR_NGROK_3200", ErrorMessage: "endpoint offline"}
+	})
+
+	var changes atomic.Int32
+	d, err := DiscoveryDialer(context.Background(), Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  staticEndpointSource(nil),
+		Quarantine: QuarantineConfig{
+			Threshold: 1,
+			Cooldown:  50 * time.Millisecond,
+			OnChange: func(hostname string, quarantined bool) {
+				if !quarantined {
+					t.Errorf("unexpected release of %s", hostname)
+				}
+				changes.Add(1)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d.Dial("tcp", "dead.example:80")
+	var quarantined *EndpointQuarantinedError
+	if _, err := d.Dial("tcp", "dead.example:80"); !errors.As(err, &quarantined) {
+		t.Fatalf("expected EndpointQuarantinedError, got %v", err)
+	}
+
+	// After the cooldown one dial is let through, and its failure re-quarantines
+	time.Sleep(60 * time.Millisecond)
+	if _, err := d.Dial("tcp", "dead.example:80"); err == nil || errors.As(err, &quarantined) {
+		t.Fatalf("expected the dial to reach the ingress after the cooldown, got %v", err)
+	}
+	if _, err := d.Dial("tcp", "dead.example:80"); !errors.As(err, &quarantined) {
+		t.Errorf("expected the endpoint to be quarantined again, got %v", err)
+	}
+	if n := len(ingress.Requests()); n != 2 {
+		t.Errorf("expected 2 binding requests, got %d", n)
+	}
+	if n := changes.Load(); n != 2 {
+		t.Errorf("expected OnChange for both quarantines, got %d calls", n)
+	}
+}
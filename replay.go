@@ -0,0 +1,91 @@
+package ngrokd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// endpointFixture is the JSON form of an Endpoint written by RecordingSource.
+type endpointFixture struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Proto    string `json:"proto,omitempty"`
+	Metadata string `json:"metadata,omitempty"`
+}
+
+// RecordingSource wraps an EndpointSource and keeps the endpoints from its last
+// successful List, so they can be saved with WriteJSON and replayed in tests
+// with ReplaySource.
+type RecordingSource struct {
+	source EndpointSource
+
+	mu       sync.Mutex
+	recorded []Endpoint
+}
+
+// NewRecordingSource returns a RecordingSource that lists from source.
+func NewRecordingSource(source EndpointSource) *RecordingSource {
+	return &RecordingSource{source: source}
+}
+
+func (s *RecordingSource) List(ctx context.Context) ([]Endpoint, error) {
+	endpoints, err := s.source.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorded = append([]Endpoint(nil), endpoints...)
+
+	return endpoints, nil
+}
+
+// WriteJSON writes the recorded endpoints to w as a JSON fixture.
+func (s *RecordingSource) WriteJSON(w io.Writer) error {
+	s.mu.Lock()
+	fixtures := make([]endpointFixture, 0, len(s.recorded))
+	for _, ep := range s.recorded {
+		fixtures = append(fixtures, endpointFixture{ID: ep.ID, URL: ep.URL.String(), Proto: ep.Proto, Metadata: ep.Metadata})
+	}
+	s.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{"endpoints": fixtures})
+}
+
+// ReplaySource is an EndpointSource that lists the endpoints of a fixture
+// written by RecordingSource, in the recorded order.
+type ReplaySource struct {
+	endpoints []Endpoint
+}
+
+// NewReplaySource reads a fixture written by RecordingSource.WriteJSON.
+func NewReplaySource(r io.Reader) (*ReplaySource, error) {
+	var fixture struct {
+		Endpoints []endpointFixture `json:"endpoints"`
+	}
+	if err := json.NewDecoder(r).Decode(&fixture); err != nil {
+		return nil, fmt.Errorf("invalid endpoint fixture: %w", err)
+	}
+
+	endpoints := make([]Endpoint, 0, len(fixture.Endpoints))
+	for _, f := range fixture.Endpoints {
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL for endpoint %s in fixture: %w", f.ID, err)
+		}
+		endpoints = append(endpoints, Endpoint{ID: f.ID, URL: u, Proto: f.Proto, Metadata: f.Metadata})
+	}
+
+	return &ReplaySource{endpoints: endpoints}, nil
+}
+
+func (s *ReplaySource) List(ctx context.Context) ([]Endpoint, error) {
+	return append([]Endpoint(nil), s.endpoints...), nil
+}
@@ -0,0 +1,69 @@
+package ngrokd
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRecordAndReplayEndpoints(t *testing.T) {
+	ctx := context.Background()
+	ingress := newTestIngress(t, nil)
+
+	endpoints := []Endpoint{
+		{ID: "ep_app", URL: mustParseURL("https://app.example"), Proto: "https", Metadata: `{"team":"web"}`},
+		{ID: "ep_db", URL: mustParseURL("tcp://db.ns:5432"), Proto: "tcp"},
+	}
+	recorder := NewRecordingSource(staticEndpointSource(endpoints))
+
+	d, err := DiscoveryDialer(ctx, Config{Cert: generateTestCert(t), EndpointSource: recorder})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := d.Endpoints(ctx); err != nil {
+		t.Fatalf("Endpoints failed: %v", err)
+	}
+
+	var fixture bytes.Buffer
+	if err := recorder.WriteJSON(&fixture); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+
+	replay, err := NewReplaySource(bytes.NewReader(fixture.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReplaySource failed: %v", err)
+	}
+	replayed, err := DiscoveryDialer(ctx, Config{
+		Cert:            generateTestCert(t),
+		IngressEndpoint: ingress.addr,
+		EndpointSource:  replay,
+		DiscoverOnStart: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cached, _ := replayed.cachedEndpoints()
+	if !reflect.DeepEqual(cached, endpoints) {
+		t.Errorf("expected replayed cache %+v, got %+v", endpoints, cached)
+	}
+
+	// Routing decisions use the replayed endpoints, e.g. tcp port lookup
+	conn, err := replayed.DialContext(ctx, "tcp", "tcp://db.ns")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+	if reqs := ingress.Requests(); len(reqs) != 1 || reqs[0].Port != 5432 {
+		t.Errorf("expected the replayed port to be dialed, got %+v", reqs)
+	}
+}
+
+func TestReplaySourceRejectsInvalidFixture(t *testing.T) {
+	for _, fixture := range []string{`not json`, `{"endpoints":[{"id":"ep_1","url":"://bad"}]}`} {
+		if _, err := NewReplaySource(bytes.NewReader([]byte(fixture))); err == nil {
+			t.Errorf("expected error for fixture %s", fixture)
+		}
+	}
+}
@@ -30,6 +30,10 @@ type FileStore struct {
 	// Dir is the directory to store certificates.
 	// Files created: tls.key, tls.crt, operator_id
 	Dir string
+
+	// Profile selects a named identity stored in a subdirectory of Dir,
+	// e.g. ~/.ngrokd-go/certs/staging. Empty uses Dir itself.
+	Profile string
 }
 
 // NewFileStore creates a FileStore with the given directory.
@@ -41,9 +45,17 @@ func NewFileStore(dir string) *FileStore {
 	return &FileStore{Dir: dir}
 }
 
-func (s *FileStore) keyPath() string      { return filepath.Join(s.Dir, "tls.key") }
-func (s *FileStore) certPath() string     { return filepath.Join(s.Dir, "tls.crt") }
-func (s *FileStore) operatorPath() string { return filepath.Join(s.Dir, "operator_id") }
+// NewFileStoreProfile creates a FileStore for a named profile under dir.
+func NewFileStoreProfile(dir, profile string) *FileStore {
+	s := NewFileStore(dir)
+	s.Profile = profile
+	return s
+}
+
+func (s *FileStore) dir() string          { return filepath.Join(s.Dir, s.Profile) }
+func (s *FileStore) keyPath() string      { return filepath.Join(s.dir(), "tls.key") }
+func (s *FileStore) certPath() string     { return filepath.Join(s.dir(), "tls.crt") }
+func (s *FileStore) operatorPath() string { return filepath.Join(s.dir(), "operator_id") }
 
 func (s *FileStore) Exists(ctx context.Context) (bool, error) {
 	_, keyErr := os.Stat(s.keyPath())
@@ -69,13 +81,14 @@ func (s *FileStore) Load(ctx context.Context) (key, cert []byte, operatorID stri
 }
 
 func (s *FileStore) CanWrite(ctx context.Context) error {
-	if err := os.MkdirAll(s.Dir, 0700); err != nil {
-		return fmt.Errorf("cannot create directory %s: %w", s.Dir, err)
+	dir := s.dir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cannot create directory %s: %w", dir, err)
 	}
 
-	testFile := filepath.Join(s.Dir, ".write_test")
+	testFile := filepath.Join(dir, ".write_test")
 	if err := os.WriteFile(testFile, []byte("test"), 0600); err != nil {
-		return fmt.Errorf("cannot write to directory %s: %w", s.Dir, err)
+		return fmt.Errorf("cannot write to directory %s: %w", dir, err)
 	}
 	os.Remove(testFile)
 
@@ -83,7 +96,7 @@ func (s *FileStore) CanWrite(ctx context.Context) error {
 }
 
 func (s *FileStore) Save(ctx context.Context, key, cert []byte, operatorID string) error {
-	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+	if err := os.MkdirAll(s.dir(), 0700); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -102,6 +115,95 @@ func (s *FileStore) Save(ctx context.Context, key, cert []byte, operatorID strin
 	return nil
 }
 
+// KeyedCertStore is a CertStore holding identities for several operators.
+// When Config.OperatorID is set, the identity for that operator is loaded
+// instead of the one returned by Load.
+type KeyedCertStore interface {
+	CertStore
+
+	// LoadOperator retrieves the certificate and private key stored for operatorID.
+	// Returns error if not found or storage fails.
+	LoadOperator(ctx context.Context, operatorID string) (key, cert []byte, err error)
+}
+
+// KeyedFileStore stores one identity per operator on the local filesystem,
+// in a subdirectory of Dir named after the operator ID. Load returns the
+// most recently saved identity.
+type KeyedFileStore struct {
+	// Dir is the directory to store certificates.
+	// Files created: <operator_id>/tls.key, <operator_id>/tls.crt,
+	// <operator_id>/operator_id, current_operator
+	Dir string
+}
+
+// NewKeyedFileStore creates a KeyedFileStore with the given directory.
+func NewKeyedFileStore(dir string) *KeyedFileStore {
+	return &KeyedFileStore{Dir: NewFileStore(dir).Dir}
+}
+
+func (s *KeyedFileStore) currentPath() string { return filepath.Join(s.Dir, "current_operator") }
+
+// operator returns the FileStore holding the identity for operatorID.
+func (s *KeyedFileStore) operator(operatorID string) (*FileStore, error) {
+	if operatorID == "" || validateProfile(operatorID) != nil {
+		return nil, fmt.Errorf("invalid operator ID %q", operatorID)
+	}
+	return &FileStore{Dir: s.Dir, Profile: operatorID}, nil
+}
+
+func (s *KeyedFileStore) current() (*FileStore, error) {
+	data, err := os.ReadFile(s.currentPath())
+	if err != nil {
+		return nil, err
+	}
+	return s.operator(string(data))
+}
+
+func (s *KeyedFileStore) Exists(ctx context.Context) (bool, error) {
+	fs, err := s.current()
+	if err != nil {
+		return false, nil
+	}
+	return fs.Exists(ctx)
+}
+
+func (s *KeyedFileStore) Load(ctx context.Context) (key, cert []byte, operatorID string, err error) {
+	fs, err := s.current()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to read current operator: %w", err)
+	}
+	return fs.Load(ctx)
+}
+
+func (s *KeyedFileStore) LoadOperator(ctx context.Context, operatorID string) (key, cert []byte, err error) {
+	fs, err := s.operator(operatorID)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, cert, _, err = fs.Load(ctx)
+	return key, cert, err
+}
+
+func (s *KeyedFileStore) CanWrite(ctx context.Context) error {
+	return (&FileStore{Dir: s.Dir}).CanWrite(ctx)
+}
+
+func (s *KeyedFileStore) Save(ctx context.Context, key, cert []byte, operatorID string) error {
+	fs, err := s.operator(operatorID)
+	if err != nil {
+		return err
+	}
+	if err := fs.Save(ctx, key, cert, operatorID); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(s.currentPath(), []byte(operatorID), 0644); err != nil {
+		return fmt.Errorf("failed to write current operator: %w", err)
+	}
+
+	return nil
+}
+
 // MemoryStore stores certificates in memory only.
 type MemoryStore struct {
 	mu         sync.RWMutex
@@ -139,3 +139,97 @@ func TestFileStore(t *testing.T) {
 		t.Errorf("operatorID mismatch")
 	}
 }
+
+func TestFileStoreProfiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	defaultStore := NewFileStore(dir)
+	staging := NewFileStoreProfile(dir, "staging")
+	prod := NewFileStoreProfile(dir, "prod")
+
+	if err := staging.Save(ctx, []byte("staging-key"), []byte("staging-cert"), "op_staging"); err != nil {
+		t.Fatalf("Save staging failed: %v", err)
+	}
+	if err := prod.Save(ctx, []byte("prod-key"), []byte("prod-cert"), "op_prod"); err != nil {
+		t.Fatalf("Save prod failed: %v", err)
+	}
+
+	if exists, _ := defaultStore.Exists(ctx); exists {
+		t.Error("expected profiles not to populate the default layout")
+	}
+
+	for _, tt := range []struct {
+		store *FileStore
+		key   string
+		opID  string
+	}{
+		{staging, "staging-key", "op_staging"},
+		{prod, "prod-key", "op_prod"},
+	} {
+		key, _, opID, err := tt.store.Load(ctx)
+		if err != nil {
+			t.Fatalf("Load %s failed: %v", tt.store.Profile, err)
+		}
+		if string(key) != tt.key || opID != tt.opID {
+			t.Errorf("profile %s: got key %s op %s, want %s %s", tt.store.Profile, key, opID, tt.key, tt.opID)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "staging", "tls.key")); err != nil {
+		t.Errorf("expected staging key in profile subdirectory: %v", err)
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	cfg := DirectConfig{Profile: "staging"}
+	if err := cfg.setDefaults(); err != nil {
+		t.Fatalf("setDefaults failed: %v", err)
+	}
+
+	store, ok := cfg.CertStore.(*FileStore)
+	if !ok || store.Profile != "staging" {
+		t.Errorf("expected default FileStore with profile staging, got %#v", cfg.CertStore)
+	}
+
+	bad := DirectConfig{Profile: "../escape"}
+	if err := bad.setDefaults(); err == nil {
+		t.Error("expected error for profile containing a path separator")
+	}
+}
+
+func TestKeyedFileStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewKeyedFileStore(t.TempDir())
+
+	if exists, _ := store.Exists(ctx); exists {
+		t.Error("expected empty store")
+	}
+
+	if err := store.Save(ctx, []byte("key-a"), []byte("cert-a"), "op_a"); err != nil {
+		t.Fatalf("Save op_a failed: %v", err)
+	}
+	if err := store.Save(ctx, []byte("key-b"), []byte("cert-b"), "op_b"); err != nil {
+		t.Fatalf("Save op_b failed: %v", err)
+	}
+
+	_, _, opID, err := store.Load(ctx)
+	if err != nil || opID != "op_b" {
+		t.Errorf("expected Load to return the last saved operator op_b, got %q (%v)", opID, err)
+	}
+
+	key, cert, err := store.LoadOperator(ctx, "op_a")
+	if err != nil {
+		t.Fatalf("LoadOperator op_a failed: %v", err)
+	}
+	if string(key) != "key-a" || string(cert) != "cert-a" {
+		t.Errorf("expected op_a identity, got key %s cert %s", key, cert)
+	}
+
+	if _, _, err := store.LoadOperator(ctx, "op_missing"); err == nil {
+		t.Error("expected error for unknown operator")
+	}
+	if err := store.Save(ctx, nil, nil, "../escape"); err == nil {
+		t.Error("expected error for operator ID containing a path separator")
+	}
+}